@@ -1,54 +1,73 @@
 package rtp
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	lksdk "github.com/livekit/server-sdk-go/v2"
 )
 
-type errCustom string
-
-func (e errCustom) Error() string {
-	return string(e)
-}
+// ACK publishes the track of a bound session into the room. When ctx is done
+// before LiveKit confirmed the publication, it fails with ctx.Err() and the
+// track is unpublished once the publication completes.
+func (r *Manager) ACK(ctx context.Context, sID string, identity string) (err error) {
+	session, ok := r.getSession(sID)
+	if !ok {
+		r.log.Infow("ACK for unknown session", "sessionID", sID, "identity", identity)
 
-const (
-	ErrNotFound = errCustom("not found")
-)
+		return fmt.Errorf("session %s (identity: %s): %w", sID, identity, ErrNotFound)
+	}
 
-func (r *Manager) ACK(sID string, identity string) error {
-	session, ok := r.session[sID]
-	if !ok {
-		fmt.Printf("ACK: track found: %s (identity: %s)\n", sID, identity)
+	session.ops.Lock()
+	defer session.ops.Unlock()
 
-		return fmt.Errorf("track found: %s (identity: %s): %w", sID, identity, ErrNotFound)
+	if err := session.expect(opACK, StateBound); err != nil {
+		return err
 	}
 
 	startedAt := time.Now()
 
 	defer func() {
-		duration := time.Since(startedAt)
-		fmt.Printf("%s: Finished ACK: (identity: %s) in %v\n", sID, identity, duration)
+		r.metrics.observe(opACK, startedAt, err)
+		session.log.Infow("finished ACK", "duration", time.Since(startedAt))
 	}()
 
-	track, rtpProvider := session.track, session.rtpProvider
+	session.mx.Lock()
+	track := session.track
+	session.mx.Unlock()
 
-	if _, err := session.room.LocalParticipant.PublishTrack(
+	publication, err := publishTrack(ctx, session.room.LocalParticipant,
 		track,
 		&lksdk.TrackPublicationOptions{
 			Name:   fmt.Sprintf("%s-%d", identity, time.Now().UnixMilli()),
 			Stream: track.StreamID(),
 		},
-	); err != nil {
-		return fmt.Errorf("failed to publish track: %w", err)
+	)
+	if err != nil {
+		return fmt.Errorf("session %s: %w: %w", sID, ErrPublishFailed, err)
 	}
 
-	fmt.Printf("%s: Started ACK: (identity: %s)\n", sID, identity)
+	session.log.Infow("published track on ACK", "trackID", track.ID(), "trackSID", publication.SID())
 
-	if err := track.StartWrite(rtpProvider, nil); err != nil {
-		return fmt.Errorf("start write to track failed:%s (identity: %s): %w", sID, identity, err)
+	session.mx.Lock()
+	defer session.mx.Unlock()
+
+	// the session was closed while publishing
+	if err := session.expectLocked(opACK, StateBound); err != nil {
+		_ = session.room.LocalParticipant.UnpublishTrack(publication.SID())
+
+		return err
 	}
 
+	if err := track.StartWrite(session.rtpProvider, nil); err != nil {
+		return fmt.Errorf("session %s: %w: start write (identity: %s): %w", sID, ErrPublishFailed, identity, err)
+	}
+
+	session.state = StateActive
+	session.writing = true
+	session.publication = publication
+	session.applyDirectionLocked()
+
 	return nil
 }
@@ -0,0 +1,404 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	opusv2 "gopkg.in/hraban/opus.v2"
+)
+
+// rewinder is an AudioSource that can start over, as needed to loop it.
+type rewinder interface {
+	Rewind() error
+}
+
+// pcmSource reads 16-bit little-endian PCM, downmixed to mono.
+type pcmSource struct {
+	r     io.ReadSeeker
+	start int64
+	// size is the number of bytes of samples, -1 up to the end of r
+	size, left int64
+
+	sampleRate, channels int
+	buf                  []byte
+}
+
+// NewRawPCMSource plays 16-bit little-endian PCM read from r, interleaved
+// when channels is 2.
+func NewRawPCMSource(r io.ReadSeeker, sampleRate, channels int) (AudioSource, error) {
+	if sampleRate <= 0 || channels < 1 || channels > 2 {
+		return nil, fmt.Errorf("unsupported raw PCM: %d Hz, %d channels", sampleRate, channels)
+	}
+
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate raw PCM: %w", err)
+	}
+
+	return &pcmSource{
+		r:          r,
+		start:      start,
+		size:       -1,
+		left:       -1,
+		sampleRate: sampleRate,
+		channels:   channels,
+	}, nil
+}
+
+// NewWAVSource plays a WAV file of 16-bit PCM, mono or stereo.
+func NewWAVSource(r io.ReadSeeker) (AudioSource, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, fmt.Errorf("failed to read WAV header: %w", err)
+	}
+
+	if string(riff[:4]) != "RIFF" || string(riff[8:]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+
+	s := &pcmSource{r: r}
+
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, fmt.Errorf("failed to read WAV chunk: %w", err)
+		}
+
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch string(chunk[:4]) {
+		case "fmt ":
+			if size < 16 {
+				return nil, errors.New("invalid WAV fmt chunk")
+			}
+
+			format := make([]byte, size)
+			if _, err := io.ReadFull(r, format); err != nil {
+				return nil, fmt.Errorf("failed to read WAV fmt chunk: %w", err)
+			}
+
+			// 1 is PCM, 0xFFFE the extensible format whose subformat we take as PCM
+			tag := binary.LittleEndian.Uint16(format[0:])
+			s.channels = int(binary.LittleEndian.Uint16(format[2:]))
+			s.sampleRate = int(binary.LittleEndian.Uint32(format[4:]))
+			bits := binary.LittleEndian.Uint16(format[14:])
+
+			if (tag != 1 && tag != 0xFFFE) || bits != 16 || s.channels < 1 || s.channels > 2 || s.sampleRate <= 0 {
+				return nil, fmt.Errorf("unsupported WAV: format %d, %d bits, %d channels, %d Hz", tag, bits, s.channels, s.sampleRate)
+			}
+		case "data":
+			if s.sampleRate == 0 {
+				return nil, errors.New("WAV data before fmt chunk")
+			}
+
+			start, err := r.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, fmt.Errorf("failed to locate WAV data: %w", err)
+			}
+
+			s.start, s.size, s.left = start, size, size
+
+			return s, nil
+		default:
+			// chunks are padded to an even size
+			if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil {
+				return nil, fmt.Errorf("failed to skip WAV chunk: %w", err)
+			}
+		}
+	}
+}
+
+func (s *pcmSource) SampleRate() int {
+	return s.sampleRate
+}
+
+func (s *pcmSource) ReadPCM(pcm []int16) (int, error) {
+	frame := 2 * s.channels
+
+	want := int64(len(pcm) * frame)
+	if s.left >= 0 {
+		want = min(want, s.left)
+	}
+
+	if want == 0 {
+		return 0, io.EOF
+	}
+
+	if int64(cap(s.buf)) < want {
+		s.buf = make([]byte, want)
+	}
+
+	n, err := io.ReadFull(s.r, s.buf[:want])
+	n -= n % frame
+
+	if s.left >= 0 {
+		s.left -= int64(n)
+	}
+
+	data := s.buf[:n]
+	for i := range n / frame {
+		var sum int
+
+		for ch := range s.channels {
+			sum += int(int16(binary.LittleEndian.Uint16(data[i*frame+2*ch:])))
+		}
+
+		pcm[i] = int16(sum / s.channels)
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+
+	if n > 0 && errors.Is(err, io.EOF) {
+		// the end is reported by the next call
+		err = nil
+	}
+
+	return n / frame, err
+}
+
+func (s *pcmSource) Rewind() error {
+	if _, err := s.r.Seek(s.start, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.left = s.size
+
+	return nil
+}
+
+// oggOpusSource decodes an Ogg Opus file, RFC 7845, to 48 kHz mono.
+type oggOpusSource struct {
+	r     io.ReadSeeker
+	start int64
+
+	decoder  *opusv2.Decoder
+	channels int
+	head     bool
+	tags     bool
+	// skip is what remains of the pre-skip of the stream, in samples
+	skip int
+	// pos counts the samples decoded, pre-skip included, as granule
+	// positions do; end is the granule position of the last page, if read
+	pos    int64
+	end    int64
+	hasEnd bool
+
+	packets [][]byte
+	partial []byte
+
+	frame []int16
+	// frameSamples is the size of the last frame decoded, to conceal the next
+	frameSamples int
+	decoded      []int16
+}
+
+// NewOggOpusSource plays an Ogg Opus file holding a single Opus stream.
+func NewOggOpusSource(r io.ReadSeeker) (AudioSource, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate Ogg stream: %w", err)
+	}
+
+	s := &oggOpusSource{
+		r:     r,
+		start: start,
+	}
+
+	// read the headers now, so a broken file fails here rather than in Play
+	if err := s.readHeaders(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *oggOpusSource) readHeaders() error {
+	for !s.head || !s.tags {
+		packet, err := s.nextPacket()
+		if err != nil {
+			return fmt.Errorf("failed to read Ogg Opus headers: %w", err)
+		}
+
+		switch {
+		case !s.head:
+			// OpusHead: magic, version, channels, pre-skip, RFC 7845 5.1
+			if len(packet) < 19 || string(packet[:8]) != "OpusHead" {
+				return errors.New("not an Ogg Opus stream")
+			}
+
+			s.channels = int(packet[9])
+			s.skip = int(binary.LittleEndian.Uint16(packet[10:]))
+
+			if s.channels < 1 || s.channels > 2 {
+				return fmt.Errorf("unsupported Ogg Opus stream with %d channels", s.channels)
+			}
+
+			if s.decoder, err = opusv2.NewDecoder(publishSampleRate, s.channels); err != nil {
+				return fmt.Errorf("failed to create Opus decoder: %w", err)
+			}
+
+			s.frame = make([]int16, maxOpusFrame*s.channels)
+			s.head = true
+		default:
+			// OpusTags carries nothing we play
+			s.tags = true
+		}
+	}
+
+	return nil
+}
+
+// nextPacket returns the next packet of the stream, assembled from Ogg pages.
+func (s *oggOpusSource) nextPacket() ([]byte, error) {
+	for len(s.packets) == 0 {
+		if err := s.readPage(); err != nil {
+			return nil, err
+		}
+	}
+
+	packet := s.packets[0]
+	s.packets = s.packets[1:]
+
+	return packet, nil
+}
+
+// readPage splits the next Ogg page into packets. A lacing value of 255
+// continues the packet in the next segment, possibly on the next page.
+func (s *oggOpusSource) readPage() error {
+	var header [27]byte
+	if _, err := io.ReadFull(s.r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return io.EOF
+		}
+
+		return err
+	}
+
+	if string(header[:4]) != "OggS" {
+		return errors.New("invalid Ogg page")
+	}
+
+	// the last page may end before its last frame does, RFC 7845 4.5
+	if granule := int64(binary.LittleEndian.Uint64(header[6:])); header[5]&oggHeaderEOS != 0 && granule >= 0 {
+		s.end, s.hasEnd = granule, true
+	}
+
+	lacing := make([]byte, header[26])
+	if _, err := io.ReadFull(s.r, lacing); err != nil {
+		return fmt.Errorf("failed to read Ogg page: %w", err)
+	}
+
+	size := 0
+	for _, l := range lacing {
+		size += int(l)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(s.r, payload); err != nil {
+		return fmt.Errorf("failed to read Ogg page: %w", err)
+	}
+
+	for _, l := range lacing {
+		s.partial = append(s.partial, payload[:l]...)
+		payload = payload[l:]
+
+		if l < 255 {
+			s.packets = append(s.packets, s.partial)
+			s.partial = nil
+		}
+	}
+
+	return nil
+}
+
+func (s *oggOpusSource) SampleRate() int {
+	return publishSampleRate
+}
+
+func (s *oggOpusSource) ReadPCM(pcm []int16) (int, error) {
+	for len(s.decoded) < len(pcm) {
+		packet, err := s.nextPacket()
+		if err != nil {
+			if len(s.decoded) > 0 && errors.Is(err, io.EOF) {
+				break
+			}
+
+			return 0, err
+		}
+
+		// DTX, nothing to play
+		if len(packet) == 0 {
+			continue
+		}
+
+		n, err := s.decode(packet)
+		if err != nil {
+			continue
+		}
+
+		for i := range n {
+			var sum int
+
+			for ch := range s.channels {
+				sum += int(s.frame[i*s.channels+ch])
+			}
+
+			s.pos++
+
+			if s.hasEnd && s.pos > s.end {
+				break
+			}
+
+			if s.skip > 0 {
+				s.skip--
+
+				continue
+			}
+
+			s.decoded = append(s.decoded, int16(sum/s.channels))
+		}
+	}
+
+	n := copy(pcm, s.decoded)
+	rest := copy(s.decoded, s.decoded[n:])
+	s.decoded = s.decoded[:rest]
+
+	return n, nil
+}
+
+// decode decodes packet into s.frame. A packet that does not decode is
+// concealed as if lost, or dropped with an error before any frame decoded.
+func (s *oggOpusSource) decode(packet []byte) (int, error) {
+	n, err := s.decoder.Decode(packet, s.frame)
+	if err == nil {
+		s.frameSamples = n
+
+		return n, nil
+	}
+
+	if s.frameSamples == 0 {
+		return 0, err
+	}
+
+	if err := s.decoder.DecodePLC(s.frame[:s.frameSamples*s.channels]); err != nil {
+		return 0, err
+	}
+
+	return s.frameSamples, nil
+}
+
+func (s *oggOpusSource) Rewind() error {
+	if _, err := s.r.Seek(s.start, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.head, s.tags = false, false
+	s.pos, s.end, s.hasEnd, s.frameSamples = 0, 0, false, 0
+	s.packets, s.partial, s.decoded = nil, nil, s.decoded[:0]
+
+	return s.readHeaders()
+}
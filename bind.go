@@ -1,48 +1,207 @@
 package rtp
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"io"
 	"net"
 	"time"
 
+	"github.com/livekit/media-sdk"
+	"github.com/livekit/media-sdk/dtmf"
 	"github.com/livekit/media-sdk/mixer"
 	"github.com/livekit/media-sdk/rtp"
 	lksdk "github.com/livekit/server-sdk-go/v2"
-	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v4"
 )
 
-const (
-	printRTCPfromClient = false
-)
+// BindOption configures optional features of the RTP leg.
+type BindOption func(*bindConfig)
 
-func (r *Manager) BindRTPtoRoom(
+type bindConfig struct {
+	dtmfPayloadType byte
+	srtp            *srtpConfig
+	rtcpMux         bool
+	dtx             bool
+}
+
+// WithTelephoneEvent enables RFC 4733 DTMF on the negotiated telephone-event
+// payload type. Events from the RTP peer are published into the room as SIP
+// DTMF data packets, and SIP DTMF packets from the room are sent as events.
+// A telephone-event codec passed to BindRTPtoRoom does the same, the one at
+// the clock rate of the audio codec first. Events run at the clock rate of
+// the telephone-event codec with payloadType in the codecs, 8000 if none.
+func WithTelephoneEvent(payloadType byte) BindOption {
+	return func(c *bindConfig) {
+		c.dtmfPayloadType = payloadType
+	}
+}
+
+// WithRTCPMux carries RTP and RTCP over connRTP (RFC 5761, a=rtcp-mux).
+// connRTCP may be nil then, and rAddrRTCP defaults to rAddrRTP.
+func WithRTCPMux() BindOption {
+	return func(c *bindConfig) {
+		c.rtcpMux = true
+	}
+}
+
+// WithSilenceSuppression stops sending audio to the RTP peer while the room
+// is silent and sends RFC 3389 comfort noise instead, on the payload type of
+// the CN codec passed to BindRTPtoRoom or the static payload type 13.
+// Comfort noise from the peer is played into the room either way.
+func WithSilenceSuppression() BindOption {
+	return func(c *bindConfig) {
+		c.dtx = true
+	}
+}
+
+func newBindConfig(opts []BindOption) *bindConfig {
+	conf := &bindConfig{}
+
+	for _, opt := range opts {
+		opt(conf)
+	}
+
+	return conf
+}
+
+type binding struct {
+	channels    int
+	streamRTP   *streamRTP
+	mediaWriter *mediaWriter[media.Writer[media.PCM16Sample]]
+	rtpProvider *rtpSampleProvider
+	mixer       *mixer.Mixer
+	dtmfStream  *eventStream
+}
+
+// sendCodec picks the codec we send with: the first audio codec of the
+// answer that is registered.
+func sendCodec(codecs []Codec) (Codec, error) {
+	for _, codec := range codecs {
+		if codec.auxiliary() {
+			continue
+		}
+
+		if _, ok := lookupCodec(codec.Name); ok {
+			return codec, nil
+		}
+	}
+
+	return Codec{}, fmt.Errorf("%w: none of %v", ErrUnsupportedCodec, codecs)
+}
+
+// comfortNoiseType is the payload type of the CN codec in codecs, the static
+// one otherwise.
+func comfortNoiseType(codecs []Codec) byte {
+	for _, codec := range codecs {
+		if codec.is(CodecComfortNoise) {
+			return codec.PayloadType
+		}
+	}
+
+	return PayloadTypeCN
+}
+
+// telephoneEvent picks the telephone-event codec of codecs running at
+// clockRate, the clock of the audio codec as RFC 4733 requires, or the first
+// one for peers that only run events at 8000.
+func telephoneEvent(codecs []Codec, clockRate int) (Codec, bool) {
+	var (
+		first Codec
+		found bool
+	)
+
+	for _, codec := range codecs {
+		if !codec.is(CodecTelephoneEvent) {
+			continue
+		}
+
+		if codec.ClockRate == clockRate {
+			return codec, true
+		}
+
+		if !found {
+			first, found = codec, true
+		}
+	}
+
+	return first, found
+}
+
+// eventClockRate is the clock rate of the telephone-event codec with
+// payloadType in codecs, dtmf.SampleRate if it is not listed.
+func eventClockRate(codecs []Codec, payloadType byte) int {
+	for _, codec := range codecs {
+		if codec.is(CodecTelephoneEvent) && codec.PayloadType == payloadType && codec.ClockRate > 0 {
+			return codec.ClockRate
+		}
+	}
+
+	return dtmf.SampleRate
+}
+
+// newBinding builds the RTP leg of a session. When prev is given, it is
+// stopped only once the new leg is complete and ctx is not done, so a failure
+// leaves it running, and the new leg continues its RTP timestamps.
+func newBinding(
+	ctx context.Context,
+	session *session,
+	prev *binding,
 	connRTP, connRTCP net.Conn,
-	sID, identity string,
-	payloadType byte, clockRate, channels, pTime int,
+	codecs []Codec, pTime int,
 	rAddrRTP, rAddrRTCP *net.UDPAddr,
-) error {
-	fmt.Printf("BindRTPtoRoom %s: Started binding to session (identity: %s) payload:%d, clockRate:%d, channels:%d, pTime:%d\n",
-		sID, identity, payloadType, clockRate, channels, pTime)
+	conf *bindConfig,
+) (*binding, error) {
+	codec, err := sendCodec(codecs)
+	if err != nil {
+		return nil, err
+	}
 
-	r.mx.Lock()
-	defer r.mx.Unlock()
+	if conf.dtmfPayloadType == 0 {
+		if event, ok := telephoneEvent(codecs, codec.ClockRate); ok {
+			conf.dtmfPayloadType = event.PayloadType
+		}
+	}
 
-	session, ok := r.session[sID]
-	if !ok {
-		return fmt.Errorf("session %s: %w", sID, ErrNotFound)
+	cnType := comfortNoiseType(codecs)
+
+	var dtxType byte
+	if conf.dtx {
+		dtxType = cnType
 	}
 
-	startedAt := time.Now()
+	mediaWriter, err := newMediaWriter(session.seqWriter, codec, pTime, session.mixerSampleRate, dtxType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create media writer: %w", err)
+	}
 
-	defer func() {
-		duration := time.Since(startedAt)
-		fmt.Printf("BindRTPtoRoom %s: Finished (identity: %s) in %v\n", sID, identity, duration)
-	}()
+	// silent until installed, the mixer starts writing to it right away
+	mediaWriter.SetSending(false, nil)
 
-	streamRTP := newStreamRTP(connRTP, connRTCP)
+	var prevSRTP *srtpContexts
+	if prev != nil {
+		prevSRTP = prev.streamRTP.srtp
+	}
+
+	srtp, err := rebindSRTPContexts(prevSRTP, conf.srtp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up SRTP: %w", err)
+	}
+
+	if conf.rtcpMux && rAddrRTCP == nil {
+		rAddrRTCP = rAddrRTP
+	}
+
+	streamRTP := newStreamRTP(
+		connRTP, connRTCP,
+		session.log.WithValues("ssrc", session.streamStats.ssrcOut),
+		conf.rtcpMux,
+		session.streamStats,
+		srtp,
+		session.timeouts,
+		func(onHold bool) { session.events.OnRTPTimeout(session.id, onHold) },
+		session.hangup,
+		func(reason string) { session.events.OnRTCPBye(session.id, reason) },
+	)
 
 	if rAddrRTP != nil {
 		streamRTP.SetRemoteAddrRTP(rAddrRTP)
@@ -52,67 +211,142 @@ func (r *Manager) BindRTPtoRoom(
 		streamRTP.SetRemoteAddrRTCP(rAddrRTCP)
 	}
 
-	mediaWriter, err := newMediaWriter(rtp.NewSeqWriter(streamRTP), payloadType, clockRate, channels, pTime)
+	rtpProvider, err := newRTPSampleProvider(streamRTP, codecs, mixChannels, conf.dtmfPayloadType, cnType, session.publishDTMF, session.streamStats)
 	if err != nil {
-		return fmt.Errorf("BindRTPtoRoom %s: failed to create media writer (identity: %s): %w", sID, identity, err)
+		return nil, fmt.Errorf("failed to create rtpProvider: %w", err)
 	}
 
-	rtpProvider, err := newRTPSampleProvider(streamRTP, payloadType, clockRate, channels)
+	mix, err := mixer.NewMixer(
+		mediaWriter,
+		rtp.DefFrameDur,
+		mixChannels,
+		mixer.WithStats(session.stats),
+		mixer.WithInputBufferFrames(mixer.DefaultInputBufferFrames),
+	)
 	if err != nil {
-		return fmt.Errorf("ACK: failed to create rtpProvider")
+		return nil, fmt.Errorf("failed to create mixer: %w", err)
 	}
 
-	udpConnRTCP := connRTCP.(*net.UDPConn)
-	track, err := lksdk.NewLocalTrack(
-		webrtc.RTPCodecCapability{
-			MimeType: webrtc.MimeTypeOpus,
-		},
-		lksdk.WithRTCPHandler(func(p rtcp.Packet) {
-			data, err := p.Marshal()
-			if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
-				return
-			}
+	// the last chance to give up with prev untouched
+	if err := ctx.Err(); err != nil {
+		mix.Stop()
 
-			if err != nil {
-				fmt.Printf("track RTCP handler: failed to marshal rtcp packet from LK: %s\n", err)
+		return nil, err
+	}
 
-				return
-			}
+	// nothing fails from here on
+	if prev != nil {
+		prev.stop()
+		mediaWriter.ContinueFrom(prev.mediaWriter)
+	}
 
-			rAddrRTCP := streamRTP.GetRemoteAddrRTCP()
+	session.streamStats.SetClockRate(codec.ClockRate)
+	streamRTP.start()
 
-			if _, err := udpConnRTCP.WriteTo(data, rAddrRTCP); err != nil {
-				if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
-					return
-				}
+	var dtmfStream *eventStream
+	if conf.dtmfPayloadType != 0 {
+		dtmfStream = newEventStream(session.seqWriter, conf.dtmfPayloadType, eventClockRate(codecs, conf.dtmfPayloadType))
+	}
 
-				fmt.Printf("track RTCP handler: failed to write rtcp packet from LK to client: %s\n", err)
-			}
-		}),
-	)
+	rtpProvider.recording = &session.recording
+	mediaWriter.recording = &session.recording
+
+	return &binding{
+		channels:    mixChannels,
+		streamRTP:   streamRTP,
+		mediaWriter: mediaWriter,
+		rtpProvider: rtpProvider,
+		mixer:       mix,
+		dtmfStream:  dtmfStream,
+	}, nil
+}
+
+func (b *binding) stop() {
+	b.mixer.Stop()
+	b.streamRTP.Detach()
+}
+
+// BindRTPtoRoom connects the RTP leg to the room of the session. codecs are
+// the codecs of the SDP answer in order of preference: we send with the first
+// one we support and accept any of them from the peer. It fails with
+// ctx.Err() when ctx is done before the binding is in place.
+func (r *Manager) BindRTPtoRoom(
+	ctx context.Context,
+	connRTP, connRTCP net.Conn,
+	sID, identity string,
+	codecs []Codec, pTime int,
+	rAddrRTP, rAddrRTCP *net.UDPAddr,
+	opts ...BindOption,
+) (err error) {
+	session, ok := r.getSession(sID)
+	if !ok {
+		return fmt.Errorf("session %s: %w", sID, ErrNotFound)
+	}
+
+	session.ops.Lock()
+	defer session.ops.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := session.expect(opBind, StateConnected); err != nil {
+		return err
+	}
+
+	log := session.log.WithValues("remoteAddr", rAddrRTP)
+	log.Infow("binding RTP to room", "codecs", codecs, "pTime", pTime)
+
+	startedAt := time.Now()
+
+	defer func() {
+		r.metrics.observe(opBind, startedAt, err)
+		log.Infow("finished binding RTP to room", "duration", time.Since(startedAt))
+	}()
+
+	binding, err := newBinding(ctx, session, nil, connRTP, connRTCP, codecs, pTime, rAddrRTP, rAddrRTCP, newBindConfig(opts))
 	if err != nil {
-		return fmt.Errorf("failed to create local track: %w", err)
+		return fmt.Errorf("BindRTPtoRoom %s: failed to bind (identity: %s): %w", sID, identity, err)
 	}
 
-	mix, err := mixer.NewMixer(
-		mediaWriter,
-		rtp.DefFrameDur,
-		channels,
-		mixer.WithStats(session.stats),
-		mixer.WithInputBufferFrames(mixer.DefaultInputBufferFrames),
+	// RTCP from LiveKit describes the WebRTC leg and is not forwarded,
+	// streamRTP sends its own reports to the peer.
+	track, err := lksdk.NewLocalTrack(
+		webrtc.RTPCodecCapability{
+			MimeType:  webrtc.MimeTypeOpus,
+			ClockRate: publishSampleRate,
+		},
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create mixer: %w", err)
+		binding.mixer.Stop()
+		binding.streamRTP.Detach()
+
+		return fmt.Errorf("failed to create local track: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		binding.stop()
+		_ = track.Close()
+
+		return err
 	}
 
-	session.SetParams(
-		channels,
-		mix,
+	if err := session.SetParams(
+		opBind,
+		binding.channels,
+		binding.mixer,
 		track,
-		rtpProvider,
-		streamRTP,
+		binding.rtpProvider,
+		binding.streamRTP,
 		connRTCP,
-	)
+		binding.mediaWriter,
+		binding.dtmfStream,
+	); err != nil {
+		binding.stop()
+		_ = track.Close()
+
+		return err
+	}
 
 	return nil
 }
@@ -0,0 +1,212 @@
+package rtp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/livekit/media-sdk"
+	"github.com/livekit/media-sdk/g711"
+	"github.com/livekit/media-sdk/g722"
+	"github.com/livekit/media-sdk/opus"
+	"github.com/livekit/protocol/logger"
+)
+
+// Encoding names of the codecs known out of the box.
+const (
+	CodecPCMU           = "PCMU"
+	CodecPCMA           = "PCMA"
+	CodecG722           = "G722"
+	CodecL16            = "L16"
+	CodecOpus           = "opus"
+	CodecTelephoneEvent = "telephone-event"
+	CodecComfortNoise   = "CN"
+)
+
+// Codec describes one codec negotiated for the RTP leg, as in the a=rtpmap
+// and a=fmtp attributes of the SDP.
+type Codec struct {
+	Name        string
+	PayloadType byte
+	ClockRate   int
+	// Channels defaults to 1.
+	Channels int
+	Fmtp     map[string]string
+}
+
+func (c Codec) String() string {
+	return fmt.Sprintf("%s/%d/%d (payload:%d)", c.Name, c.ClockRate, c.channels(), c.PayloadType)
+}
+
+func (c Codec) channels() int {
+	if c.Channels == 0 {
+		return 1
+	}
+
+	return c.Channels
+}
+
+// is tells if c has the encoding name, which SDP compares case-insensitively.
+func (c Codec) is(name string) bool {
+	return strings.EqualFold(c.Name, name)
+}
+
+// auxiliary tells if c is carried next to the audio codec rather than being
+// one: telephone-event or comfort noise.
+func (c Codec) auxiliary() bool {
+	return c.is(CodecTelephoneEvent) || c.is(CodecComfortNoise)
+}
+
+// PayloadWriter takes the payloads of a codec, one per RTP packet. Its
+// SampleRate is the rate of the PCM on the other side of the codec.
+type PayloadWriter = media.WriteCloser[[]byte]
+
+// CodecFactory plugs an audio codec into the RTP leg.
+type CodecFactory struct {
+	// SampleRate is the rate of the PCM the codec encodes and decodes,
+	// 0 if it is the RTP clock rate.
+	SampleRate int
+
+	// NewEncoder returns a writer encoding PCM at the sample rate into w.
+	NewEncoder func(codec Codec, w PayloadWriter) (media.PCM16Writer, error)
+
+	// NewDecoder returns a writer decoding payloads into PCM written to w.
+	NewDecoder func(codec Codec, w media.PCM16Writer) (PayloadWriter, error)
+}
+
+func (f CodecFactory) sampleRate(codec Codec) int {
+	if f.SampleRate == 0 {
+		return codec.ClockRate
+	}
+
+	return f.SampleRate
+}
+
+var (
+	codecsMx sync.RWMutex
+	codecs   = make(map[string]CodecFactory)
+)
+
+// RegisterCodec makes the codec with the encoding name usable in
+// BindRTPtoRoom, replacing a codec registered before under the same name.
+func RegisterCodec(name string, factory CodecFactory) {
+	codecsMx.Lock()
+	defer codecsMx.Unlock()
+
+	codecs[strings.ToLower(name)] = factory
+}
+
+func lookupCodec(name string) (CodecFactory, bool) {
+	codecsMx.RLock()
+	defer codecsMx.RUnlock()
+
+	factory, ok := codecs[strings.ToLower(name)]
+
+	return factory, ok
+}
+
+func init() {
+	RegisterCodec(CodecPCMU, CodecFactory{
+		NewEncoder: func(_ Codec, w PayloadWriter) (media.PCM16Writer, error) {
+			return g711.EncodeULaw(writeAs[g711.ULawSample](w)), nil
+		},
+		NewDecoder: func(_ Codec, w media.PCM16Writer) (PayloadWriter, error) {
+			return readAs[g711.ULawSample](g711.DecodeULaw(w)), nil
+		},
+	})
+
+	RegisterCodec(CodecPCMA, CodecFactory{
+		NewEncoder: func(_ Codec, w PayloadWriter) (media.PCM16Writer, error) {
+			return g711.EncodeALaw(writeAs[g711.ALawSample](w)), nil
+		},
+		NewDecoder: func(_ Codec, w media.PCM16Writer) (PayloadWriter, error) {
+			return readAs[g711.ALawSample](g711.DecodeALaw(w)), nil
+		},
+	})
+
+	// the RTP clock of G.722 runs at 8000 for 16 kHz audio, RFC 3551 4.5.2
+	RegisterCodec(CodecG722, CodecFactory{
+		SampleRate: g722SampleRate,
+		NewEncoder: func(_ Codec, w PayloadWriter) (media.PCM16Writer, error) {
+			return g722.Encode(writeAs[g722.Sample](w)), nil
+		},
+		NewDecoder: func(_ Codec, w media.PCM16Writer) (PayloadWriter, error) {
+			return readAs[g722.Sample](g722.Decode(w)), nil
+		},
+	})
+
+	RegisterCodec(CodecL16, CodecFactory{
+		NewEncoder: func(codec Codec, w PayloadWriter) (media.PCM16Writer, error) {
+			return encodeL16(w, codec.channels()), nil
+		},
+		NewDecoder: func(codec Codec, w media.PCM16Writer) (PayloadWriter, error) {
+			return decodeL16(w, codec.channels()), nil
+		},
+	})
+
+	// opus/48000/2 is how RFC 7587 names Opus in any case, a mono stream
+	// decodes from it and is a valid stream to send, so the mix stays mono
+	RegisterCodec(CodecOpus, CodecFactory{
+		NewEncoder: func(_ Codec, w PayloadWriter) (media.PCM16Writer, error) {
+			return opus.Encode(writeAs[opus.Sample](w), mixChannels, logger.GetLogger())
+		},
+		NewDecoder: func(_ Codec, w media.PCM16Writer) (PayloadWriter, error) {
+			decoder, err := opus.Decode(w, mixChannels, logger.GetLogger())
+			if err != nil {
+				return nil, err
+			}
+
+			return readAs[opus.Sample](decoder), nil
+		},
+	})
+}
+
+// writeAs lets a codec writing samples of type S write to a PayloadWriter.
+func writeAs[S ~[]byte](w PayloadWriter) media.WriteCloser[S] {
+	return &payloadWriterAs[S]{w: w}
+}
+
+type payloadWriterAs[S ~[]byte] struct {
+	w PayloadWriter
+}
+
+func (p *payloadWriterAs[S]) String() string {
+	return p.w.String()
+}
+
+func (p *payloadWriterAs[S]) SampleRate() int {
+	return p.w.SampleRate()
+}
+
+func (p *payloadWriterAs[S]) WriteSample(sample S) error {
+	return p.w.WriteSample([]byte(sample))
+}
+
+func (p *payloadWriterAs[S]) Close() error {
+	return p.w.Close()
+}
+
+// readAs lets a codec reading samples of type S be used as a PayloadWriter.
+func readAs[S ~[]byte](w media.WriteCloser[S]) PayloadWriter {
+	return &sampleWriterAs[S]{w: w}
+}
+
+type sampleWriterAs[S ~[]byte] struct {
+	w media.WriteCloser[S]
+}
+
+func (s *sampleWriterAs[S]) String() string {
+	return s.w.String()
+}
+
+func (s *sampleWriterAs[S]) SampleRate() int {
+	return s.w.SampleRate()
+}
+
+func (s *sampleWriterAs[S]) WriteSample(payload []byte) error {
+	return s.w.WriteSample(S(payload))
+}
+
+func (s *sampleWriterAs[S]) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,110 @@
+package rtp
+
+import (
+	"testing"
+
+	"github.com/livekit/media-sdk"
+	"github.com/livekit/media-sdk/rtp"
+)
+
+// byteEncoder encodes each sample into its low byte.
+type byteEncoder struct {
+	w PayloadWriter
+}
+
+func (e *byteEncoder) String() string {
+	return "byteEncoder"
+}
+
+func (e *byteEncoder) SampleRate() int {
+	return e.w.SampleRate()
+}
+
+func (e *byteEncoder) WriteSample(sample media.PCM16Sample) error {
+	payload := make([]byte, len(sample))
+	for i, v := range sample {
+		payload[i] = byte(v)
+	}
+
+	return e.w.WriteSample(payload)
+}
+
+func (e *byteEncoder) Close() error {
+	return e.w.Close()
+}
+
+func TestRegisterCodec(t *testing.T) {
+	for _, name := range []string{"pcmu", "PCMA", "g722", "l16", "OPUS"} {
+		if _, ok := lookupCodec(name); !ok {
+			t.Errorf("built-in codec %s not found", name)
+		}
+	}
+
+	if _, ok := lookupCodec("X-BYTES"); ok {
+		t.Fatal("X-BYTES registered before the test")
+	}
+
+	var created []Codec
+
+	RegisterCodec("x-bytes", CodecFactory{
+		SampleRate: 8000,
+		NewEncoder: func(codec Codec, w PayloadWriter) (media.PCM16Writer, error) {
+			t.Fatal("replaced factory used")
+
+			return nil, nil
+		},
+	})
+
+	RegisterCodec("X-Bytes", CodecFactory{
+		SampleRate: 16000,
+		NewEncoder: func(codec Codec, w PayloadWriter) (media.PCM16Writer, error) {
+			created = append(created, codec)
+
+			return &byteEncoder{w: w}, nil
+		},
+	})
+
+	codec := Codec{Name: "X-BYTES", PayloadType: 96, ClockRate: 8000}
+
+	out := &packetBuffer{}
+
+	w, err := newMediaWriter(rtp.NewSeqWriter(out), codec, 20, 16000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 20 ms at the rate of the codec
+	frame := make(media.PCM16Sample, 320)
+	for i := range frame {
+		frame[i] = int16(i)
+	}
+
+	for range 2 {
+		if err := w.WriteSample(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(created) != 1 || created[0].Name != codec.Name || created[0].PayloadType != codec.PayloadType {
+		t.Fatalf("encoders created for %v, want one for %v", created, codec)
+	}
+
+	if len(out.packets) != 2 {
+		t.Fatalf("%d packets, want 2", len(out.packets))
+	}
+
+	for i, pkt := range out.packets {
+		if pkt.PayloadType != 96 || len(pkt.Payload) != 320 || pkt.Payload[1] != 1 {
+			t.Errorf("packet %d: pt %d, %d bytes, want pt 96 with 320 encoded samples", i, pkt.PayloadType, len(pkt.Payload))
+		}
+	}
+
+	// the RTP clock advances at the clock rate, not the sample rate
+	if d := out.packets[1].Timestamp - out.packets[0].Timestamp; d != 160 {
+		t.Errorf("timestamps %d apart, want 160", d)
+	}
+
+	if _, err := newMediaWriter(rtp.NewSeqWriter(out), Codec{Name: "X-UNKNOWN", PayloadType: 97, ClockRate: 8000}, 20, 16000, 0); err == nil {
+		t.Error("writer created for an unregistered codec")
+	}
+}
@@ -0,0 +1,142 @@
+package rtp
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/livekit/media-sdk"
+	"github.com/livekit/media-sdk/rtp"
+)
+
+// Comfort noise, RFC 3389. A CN payload starts with the noise level in -dBov,
+// optionally followed by reflection coefficients shaping its spectrum. Only
+// the level is used: we generate white noise and send level-only payloads.
+const (
+	// cnMinLevel is the quietest noise level, in -dBov.
+	cnMinLevel = 127
+
+	// cnLevelChange is the drift of the noise level, in dB, that is sent
+	// to the peer before cnRefreshFrames have passed.
+	cnLevelChange   = 3
+	cnRefreshFrames = 25
+
+	// vadMargin is how much louder than the noise floor a frame is voiced, in dB.
+	vadMargin = 9
+	// vadSilence is the level, in dBov, below which a frame is never voiced.
+	vadSilence = -55
+	// vadHangover is how many frames stay voiced after speech ends, so word
+	// endings are not clipped.
+	vadHangover = 10
+	// vadFloorRise is how fast the noise floor follows louder frames, in dB per frame.
+	vadFloorRise = 0.1
+)
+
+// pcmLevel is the level of pcm in dBov, 0 for a full scale square wave.
+func pcmLevel(pcm []int16) float64 {
+	if len(pcm) == 0 {
+		return -cnMinLevel
+	}
+
+	var sum float64
+	for _, v := range pcm {
+		sum += float64(v) * float64(v)
+	}
+
+	level := 10 * math.Log10(sum/float64(len(pcm))/(32768*32768))
+
+	return max(level, -cnMinLevel)
+}
+
+// cnLevel is the noise level byte of a CN payload for a level in dBov.
+func cnLevel(level float64) byte {
+	return byte(min(max(math.Round(-level), 0), cnMinLevel))
+}
+
+// comfortNoise generates the background noise announced by the peer in CN
+// packets, until audio arrives again.
+type comfortNoise struct {
+	active bool
+	rms    float64
+}
+
+// Update takes a CN payload of the peer.
+func (n *comfortNoise) Update(payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+
+	level := payload[0] & 0x7f
+	n.active = true
+	n.rms = 32768 * math.Pow(10, -float64(level)/20)
+}
+
+// Stop ends the noise, audio is back.
+func (n *comfortNoise) Stop() {
+	n.active = false
+}
+
+// Generate fills pcm with noise at the level of the peer.
+func (n *comfortNoise) Generate(pcm []int16) {
+	for i := range pcm {
+		pcm[i] = clampPCM(rand.NormFloat64() * n.rms)
+	}
+}
+
+// silenceSuppressor is a voice activity detector for the mix sent to the
+// peer (DTX). Silent frames are not sent; CN packets describing the
+// background noise are sent instead, when it starts and when it changes.
+type silenceSuppressor struct {
+	stream *rtp.Stream
+
+	floor    float64
+	hangover int
+	silent   bool
+
+	sent      byte
+	sinceSent int
+}
+
+func newSilenceSuppressor(stream *rtp.Stream) *silenceSuppressor {
+	return &silenceSuppressor{
+		stream: stream,
+		floor:  -cnMinLevel,
+	}
+}
+
+// Voiced tells if the frame is to be sent as audio, and if it starts a
+// talkspurt. For silent frames it sends a CN packet at timestamp when one is due.
+func (v *silenceSuppressor) Voiced(pcm media.PCM16Sample, timestamp uint32) (voiced, talkspurt bool, err error) {
+	level := pcmLevel(pcm)
+
+	// the floor drops to quiet frames at once and rises slowly under speech
+	if level < v.floor {
+		v.floor = level
+	} else {
+		v.floor = min(v.floor+vadFloorRise, level)
+	}
+
+	if level > vadSilence && level > v.floor+vadMargin {
+		v.hangover = vadHangover
+	} else if v.hangover > 0 {
+		v.hangover--
+	}
+
+	if v.hangover > 0 {
+		talkspurt, v.silent = v.silent, false
+
+		return true, talkspurt, nil
+	}
+
+	noise := cnLevel(level)
+
+	v.sinceSent++
+	if v.silent && v.sinceSent < cnRefreshFrames && math.Abs(float64(noise)-float64(v.sent)) < cnLevelChange {
+		return false, false, nil
+	}
+
+	v.silent, v.sent, v.sinceSent = true, noise, 0
+
+	v.stream.ResetTimestamp(timestamp)
+
+	return false, false, v.stream.WritePayloadAtCurrent([]byte{noise}, false)
+}
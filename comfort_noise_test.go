@@ -0,0 +1,182 @@
+package rtp
+
+import (
+	"testing"
+
+	"github.com/livekit/media-sdk"
+	"github.com/livekit/media-sdk/rtp"
+)
+
+// dcFrame is a 20 ms frame at 8 kHz of constant value v, at 20*log10(v/32768) dBov.
+func dcFrame(v int16) media.PCM16Sample {
+	pcm := make(media.PCM16Sample, 160)
+	for i := range pcm {
+		pcm[i] = v
+	}
+
+	return pcm
+}
+
+func TestSilenceSuppressor(t *testing.T) {
+	const (
+		silence = 0
+		quiet   = 10    // -70 dBov
+		noise   = 33    // -60 dBov, quieter than vadSilence
+		louder  = 66    // -54 dBov, louder than vadSilence, within vadMargin of noise
+		speech  = 10000 // -10 dBov
+	)
+
+	// noCN marks frames without a CN packet, anyCN frames are not checked
+	const (
+		noCN  = -1
+		anyCN = -2
+	)
+
+	type frame struct {
+		value     int16
+		count     int
+		voiced    bool
+		talkspurt bool
+		cn        int
+	}
+
+	cases := []struct {
+		name   string
+		frames []frame
+	}{
+		{
+			name: "silence sends CN once",
+			frames: []frame{
+				{value: silence, cn: cnMinLevel},
+				{value: silence, count: cnRefreshFrames - 2, cn: noCN},
+			},
+		},
+		{
+			name: "CN refreshed",
+			frames: []frame{
+				{value: silence, cn: cnMinLevel},
+				{value: silence, count: cnRefreshFrames - 1, cn: noCN},
+				{value: silence, cn: cnMinLevel},
+			},
+		},
+		{
+			name: "speech starts a talkspurt",
+			frames: []frame{
+				{value: silence, cn: cnMinLevel},
+				{value: speech, voiced: true, talkspurt: true, cn: noCN},
+				{value: speech, count: 3, voiced: true, cn: noCN},
+			},
+		},
+		{
+			name: "first frame is no talkspurt",
+			frames: []frame{
+				{value: speech, voiced: true, cn: noCN},
+			},
+		},
+		{
+			name: "hangover",
+			frames: []frame{
+				{value: speech, voiced: true, cn: noCN},
+				{value: silence, count: vadHangover - 1, voiced: true, cn: noCN},
+				{value: silence, cn: cnMinLevel},
+				{value: speech, voiced: true, talkspurt: true, cn: noCN},
+			},
+		},
+		{
+			name: "noise level change",
+			frames: []frame{
+				{value: silence, cn: cnMinLevel},
+				{value: noise, cn: 60},
+				{value: noise, count: 3, cn: noCN},
+				{value: quiet, cn: 70},
+				{value: quiet, count: 3, cn: noCN},
+			},
+		},
+		{
+			name: "noise within the margin of the floor",
+			frames: []frame{
+				// the floor rises to the noise in vadFloorRise steps
+				{value: noise, count: 700, cn: anyCN},
+				{value: louder, cn: 54},
+				{value: louder, count: 5, cn: noCN},
+				{value: speech, voiced: true, talkspurt: true, cn: noCN},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &packetBuffer{}
+			v := newSilenceSuppressor(rtp.NewSeqWriter(out).NewStream(PayloadTypeCN, 8000))
+
+			var ts uint32
+
+			for i, f := range tc.frames {
+				for range max(f.count, 1) {
+					sent := len(out.packets)
+
+					voiced, talkspurt, err := v.Voiced(dcFrame(f.value), ts)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if voiced != f.voiced || talkspurt != f.talkspurt {
+						t.Fatalf("step %d: voiced %v, talkspurt %v, want %v, %v", i, voiced, talkspurt, f.voiced, f.talkspurt)
+					}
+
+					switch f.cn {
+					case anyCN:
+					case noCN:
+						if len(out.packets) != sent {
+							t.Fatalf("step %d: unexpected CN packet %v", i, out.packets[sent].Payload)
+						}
+					default:
+						if len(out.packets) != sent+1 {
+							t.Fatalf("step %d: no CN packet", i)
+						}
+
+						pkt := out.packets[sent]
+						if pkt.PayloadType != PayloadTypeCN || pkt.Timestamp != ts || len(pkt.Payload) != 1 || int(pkt.Payload[0]) != f.cn {
+							t.Fatalf("step %d: CN packet pt %d ts %d payload %v, want pt %d ts %d level %d",
+								i, pkt.PayloadType, pkt.Timestamp, pkt.Payload, PayloadTypeCN, ts, f.cn)
+						}
+					}
+
+					ts += 160
+				}
+			}
+		})
+	}
+}
+
+func TestComfortNoiseLevel(t *testing.T) {
+	cases := []struct {
+		level byte
+		rms   float64
+	}{
+		{level: 0, rms: 32768},
+		{level: 20, rms: 3276.8},
+		{level: 60, rms: 32.768},
+		// the reserved bit is ignored
+		{level: 0x80 | 60, rms: 32.768},
+	}
+
+	for _, tc := range cases {
+		var n comfortNoise
+		n.Update([]byte{tc.level})
+
+		if !n.active || n.rms < tc.rms*0.999 || n.rms > tc.rms*1.001 {
+			t.Errorf("level %d: active %v, rms %v, want %v", tc.level, n.active, n.rms, tc.rms)
+		}
+	}
+
+	pcm := make([]int16, 8000)
+
+	var n comfortNoise
+	n.Update([]byte{40})
+	n.Generate(pcm)
+
+	if got := pcmLevel(pcm); got < -41 || got > -39 {
+		t.Errorf("generated noise at %.1f dBov, want -40", got)
+	}
+}
@@ -0,0 +1,238 @@
+package rtp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/livekit/media-sdk/dtmf"
+	"github.com/livekit/media-sdk/rtp"
+	"github.com/livekit/protocol/livekit"
+	"github.com/livekit/protocol/logger"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+// dtmfReceiver turns RFC 4733 telephone-event packets into one callback per
+// digit. Packets of the same event share a timestamp, so the event is
+// reported once even if the packet with the marker bit got lost or the end
+// packet is retransmitted.
+type dtmfReceiver struct {
+	handler dtmf.Handler
+	log     logger.Logger
+	invalid logLimit
+
+	started   bool
+	timestamp uint32
+}
+
+func newDTMFReceiver(handler dtmf.Handler, log logger.Logger) *dtmfReceiver {
+	return &dtmfReceiver{
+		handler: handler,
+		log:     log,
+	}
+}
+
+func (d *dtmfReceiver) HandleRTP(h *rtp.Header, payload []byte) {
+	ev, err := dtmf.Decode(payload)
+	if err != nil {
+		d.invalid.Debugw(d.log, "failed to decode telephone-event", "error", err, "remoteSSRC", h.SSRC)
+
+		return
+	}
+
+	if d.started && d.timestamp == h.Timestamp {
+		return
+	}
+
+	d.started, d.timestamp = true, h.Timestamp
+
+	if d.handler != nil {
+		d.handler(ev)
+	}
+}
+
+func (s *session) publishDTMF(ev dtmf.Event) {
+	if err := s.room.LocalParticipant.PublishDataPacket(
+		&livekit.SipDTMF{
+			Code:  uint32(ev.Code),
+			Digit: string([]byte{ev.Digit}),
+		},
+		lksdk.WithDataPublishReliable(true),
+	); err != nil {
+		s.log.Warnw("failed to publish DTMF", err, "digit", string(ev.Digit))
+	}
+}
+
+// writeDTMF emits digits as RFC 4733 events on the RTP leg. Digits are sent
+// one call at a time, so events from concurrent packets do not overlap.
+func (s *session) writeDTMF(ctx context.Context, digits string) error {
+	s.dtmfMx.Lock()
+	defer s.dtmfMx.Unlock()
+
+	s.mx.Lock()
+	events, mediaWriter := s.dtmfStream, s.mediaWriter
+	s.mx.Unlock()
+
+	if events == nil || mediaWriter == nil {
+		return fmt.Errorf("telephone-event is not negotiated")
+	}
+
+	if err := events.write(ctx, mediaWriter.rtpWriter.GetCurrentTimestamp(), digits); err != nil {
+		return fmt.Errorf("failed to write DTMF %q: %w", digits, err)
+	}
+
+	return nil
+}
+
+// the timing of dtmf.Write: each digit is an event followed by a pause as
+// long, 'w' pauses for dtmfPauseDur
+const (
+	dtmfEventDur  = 250 * time.Millisecond
+	dtmfPauseDur  = time.Second / 2
+	dtmfVolume    = 10
+	dtmfEndRepeat = 3
+)
+
+// eventStream sends RFC 4733 events at the clock rate of the negotiated
+// telephone-event codec. dtmf.Write only counts at 8000, while the event
+// clock has to match the audio clock, 48000 next to Opus.
+type eventStream struct {
+	*rtp.Stream
+	clockRate int
+}
+
+func newEventStream(w *rtp.SeqWriter, payloadType byte, clockRate int) *eventStream {
+	return &eventStream{
+		Stream:    w.NewStream(payloadType, clockRate),
+		clockRate: clockRate,
+	}
+}
+
+// samples converts d to units of the event clock.
+func (e *eventStream) samples(d time.Duration) uint32 {
+	return uint32(d * time.Duration(e.clockRate) / time.Second)
+}
+
+// write sends digits as events starting at startTs, the way dtmf.Write
+// does: every packet of an event carries its timestamp and the duration so
+// far, the end packet is sent three times, and each event is followed by a
+// pause as long. Digits without an event are skipped.
+func (e *eventStream) write(ctx context.Context, startTs uint32, digits string) error {
+	ticker := time.NewTicker(rtp.DefFrameDur)
+	defer ticker.Stop()
+
+	wait := func(n int) error {
+		for range n {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+		}
+
+		return nil
+	}
+
+	e.ResetTimestamp(startTs)
+
+	const (
+		eventFrames = int(dtmfEventDur / rtp.DefFrameDur)
+		pauseFrames = int(dtmfPauseDur / rtp.DefFrameDur)
+	)
+
+	var buf [4]byte
+
+	for i := 0; i < len(digits); i++ {
+		if digits[i] == 'w' {
+			e.Delay(e.samples(dtmfPauseDur))
+
+			if err := wait(pauseFrames); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		code, freq := dtmf.Tone(digits[i])
+		if freq == nil {
+			continue
+		}
+
+		for frame := 1; frame <= eventFrames; frame++ {
+			if err := wait(1); err != nil {
+				return err
+			}
+
+			end := frame == eventFrames
+
+			n, err := dtmf.Encode(buf[:], dtmf.Event{
+				Code:   code,
+				Volume: dtmfVolume,
+				Dur:    uint16(e.samples(time.Duration(frame) * rtp.DefFrameDur)),
+				End:    end,
+			})
+			if err != nil {
+				return err
+			}
+
+			repeat := 1
+			if end {
+				repeat = dtmfEndRepeat
+			}
+
+			for range repeat {
+				// all packets of an event share its timestamp
+				if err := e.WritePayloadAtCurrent(buf[:n], frame == 1); err != nil {
+					return err
+				}
+			}
+		}
+
+		e.Delay(e.samples(2 * dtmfEventDur))
+
+		if err := wait(eventFrames); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Manager) receiveDataPacket(sID, identity string) func(lksdk.DataPacket, lksdk.DataReceiveParams) {
+	return func(data lksdk.DataPacket, params lksdk.DataReceiveParams) {
+		pkt, ok := data.(*livekit.SipDTMF)
+		if !ok {
+			return
+		}
+
+		session, ok := r.getSession(sID)
+		if !ok {
+			r.log.Infow("DTMF for unknown session", "sessionID", sID, "identity", identity)
+
+			return
+		}
+
+		// media-sdk only knows lowercase A-D
+		digit := strings.ToLower(pkt.Digit)
+		if digit == "" {
+			digit = string(dtmfDigit(byte(pkt.Code)))
+		}
+
+		go func() {
+			if err := session.writeDTMF(context.Background(), digit); err != nil {
+				session.log.Warnw("failed to send DTMF", err, "participant", params.SenderIdentity)
+			}
+		}()
+	}
+}
+
+// dtmfDigit maps an RFC 4733 event code to its digit.
+func dtmfDigit(code byte) byte {
+	ev, err := dtmf.Decode([]byte{code, 0, 0, 0})
+	if err != nil {
+		return 0
+	}
+
+	return ev.Digit
+}
@@ -0,0 +1,89 @@
+package rtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/livekit/server-sdk-go/v2/signalling"
+)
+
+type errCustom string
+
+func (e errCustom) Error() string {
+	return string(e)
+}
+
+// Errors of the Manager, to be matched with errors.Is. The errors returned
+// wrap them with the session and the underlying cause.
+const (
+	// ErrNotFound is returned for a session ID that is not, or no longer, known.
+	ErrNotFound = errCustom("not found")
+	// ErrInvalidState is matched by every StateError.
+	ErrInvalidState = errCustom("invalid session state")
+	// ErrSessionNotBound is returned by ACK and RebindRTP before BindRTPtoRoom.
+	ErrSessionNotBound = errCustom("session is not bound")
+	// ErrAlreadyBound is returned by BindRTPtoRoom on a bound session, RebindRTP moves it.
+	ErrAlreadyBound = errCustom("session is already bound")
+	// ErrAlreadyActive is returned by ACK on a session whose track is published already.
+	ErrAlreadyActive = errCustom("session is already active")
+	// ErrSessionClosed is returned for a session that is being or has been torn down.
+	ErrSessionClosed = errCustom("session is closed")
+	// ErrMediaTimeout is returned for a session hung up by the media timeouts,
+	// see EndReason.Err.
+	ErrMediaTimeout = errCustom("media timeout")
+	// ErrUnsupportedCodec is returned when none of the codecs given can be used.
+	ErrUnsupportedCodec = errCustom("unsupported codec")
+	// ErrRoomConnectFailed is matched by every RoomConnectError.
+	ErrRoomConnectFailed = errCustom("failed to connect to room")
+	// ErrPublishFailed is returned when a track could not be published into the room.
+	ErrPublishFailed = errCustom("failed to publish track")
+)
+
+// RoomConnectError is returned by ConnectToRoom when the room could not be
+// joined. Err is the reason given by LiveKit, or ctx.Err(). It matches
+// ErrRoomConnectFailed with errors.Is.
+type RoomConnectError struct {
+	Room string
+	Err  error
+	// Retryable is set when the same join may succeed later, as after a
+	// timeout or a failure to reach the server. Errors the SDK does not
+	// type, such as rejected credentials, are not retryable.
+	Retryable bool
+}
+
+func newRoomConnectError(room string, err error) *RoomConnectError {
+	return &RoomConnectError{
+		Room:      room,
+		Err:       err,
+		Retryable: retryableConnectError(err),
+	}
+}
+
+func (e *RoomConnectError) Error() string {
+	return fmt.Sprintf("%s %s: %v", ErrRoomConnectFailed, e.Room, e.Err)
+}
+
+func (e *RoomConnectError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RoomConnectError) Is(target error) bool {
+	return target == ErrRoomConnectFailed
+}
+
+func retryableConnectError(err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, lksdk.ErrConnectionTimeout),
+		errors.Is(err, lksdk.ErrCannotConnectSignal),
+		errors.Is(err, signalling.ErrCannotDialSignal):
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr)
+}
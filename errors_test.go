@@ -0,0 +1,120 @@
+package rtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/livekit/server-sdk-go/v2/signalling"
+)
+
+func TestStateErrorIs(t *testing.T) {
+	all := []error{ErrInvalidState, ErrSessionNotBound, ErrAlreadyBound, ErrAlreadyActive, ErrSessionClosed, ErrMediaTimeout}
+
+	cases := []struct {
+		name string
+		err  *StateError
+		want []error
+	}{
+		{
+			name: "ack before bind",
+			err:  &StateError{Op: opACK, State: StateConnected},
+			want: []error{ErrInvalidState, ErrSessionNotBound},
+		},
+		{
+			name: "rebind before bind",
+			err:  &StateError{Op: opRebind, State: StateConnecting},
+			want: []error{ErrInvalidState, ErrSessionNotBound},
+		},
+		{
+			name: "bind while connecting",
+			err:  &StateError{Op: opBind, State: StateConnecting},
+			want: []error{ErrInvalidState},
+		},
+		{
+			name: "bind twice",
+			err:  &StateError{Op: opBind, State: StateBound},
+			want: []error{ErrInvalidState, ErrAlreadyBound},
+		},
+		{
+			name: "bind when active",
+			err:  &StateError{Op: opBind, State: StateActive},
+			want: []error{ErrInvalidState, ErrAlreadyBound},
+		},
+		{
+			name: "ack twice",
+			err:  &StateError{Op: opACK, State: StateActive},
+			want: []error{ErrInvalidState, ErrAlreadyActive},
+		},
+		{
+			name: "disconnected",
+			err:  &StateError{Op: opACK, State: StateClosed, Reason: EndReasonDisconnected},
+			want: []error{ErrInvalidState, ErrSessionClosed},
+		},
+		{
+			name: "media timeout",
+			err:  &StateError{Op: opRebind, State: StateClosing, Reason: EndReasonMediaTimeout},
+			want: []error{ErrInvalidState, ErrSessionClosed, ErrMediaTimeout},
+		},
+		{
+			name: "hold timeout",
+			err:  &StateError{Op: opPlay, State: StateClosed, Reason: EndReasonHoldTimeout},
+			want: []error{ErrInvalidState, ErrSessionClosed, ErrMediaTimeout},
+		},
+	}
+
+	for _, tc := range cases {
+		// as returned by the Manager
+		err := fmt.Errorf("wrapped: %w", tc.err)
+
+		for _, target := range all {
+			if got, want := errors.Is(err, target), slices.Contains(tc.want, target); got != want {
+				t.Errorf("%s: errors.Is(%v) = %v, want %v", tc.name, target, got, want)
+			}
+		}
+	}
+}
+
+func TestEndReasonErr(t *testing.T) {
+	if err := EndReasonDisconnected.Err(); err != nil {
+		t.Errorf("disconnected: %v, want nil", err)
+	}
+
+	for _, reason := range []EndReason{EndReasonMediaTimeout, EndReasonHoldTimeout} {
+		if err := reason.Err(); !errors.Is(err, ErrMediaTimeout) {
+			t.Errorf("%s: %v, want ErrMediaTimeout", reason, err)
+		}
+	}
+}
+
+func TestRoomConnectError(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{name: "deadline", err: context.DeadlineExceeded, retryable: true},
+		{name: "canceled", err: context.Canceled},
+		{name: "connection timeout", err: lksdk.ErrConnectionTimeout, retryable: true},
+		{name: "cannot connect signal", err: fmt.Errorf("join: %w", lksdk.ErrCannotConnectSignal), retryable: true},
+		{name: "cannot dial signal", err: signalling.ErrCannotDialSignal, retryable: true},
+		{name: "network", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, retryable: true},
+		{name: "unauthorized", err: errors.New("unauthorized: invalid token")},
+	}
+
+	for _, tc := range cases {
+		err := newRoomConnectError("room", tc.err)
+
+		if err.Retryable != tc.retryable {
+			t.Errorf("%s: retryable %v, want %v", tc.name, err.Retryable, tc.retryable)
+		}
+
+		if !errors.Is(err, ErrRoomConnectFailed) || !errors.Is(err, tc.err) {
+			t.Errorf("%s: %v does not match ErrRoomConnectFailed and its cause", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package rtp
+
+import (
+	"fmt"
+	"time"
+)
+
+// EndReason tells why a session ended.
+type EndReason string
+
+const (
+	// EndReasonDisconnected is reported when DisconnectFromRoom tears the session down.
+	EndReasonDisconnected = EndReason("disconnected")
+	// EndReasonMediaTimeout is reported when the RTP peer stopped sending
+	// media, see MediaTimeouts.
+	EndReasonMediaTimeout = EndReason("media timeout")
+	// EndReasonHoldTimeout is reported when the RTP peer stayed on hold for too long.
+	EndReasonHoldTimeout = EndReason("hold timeout")
+	// EndReasonReconnectFailed is reported when the connection to the room
+	// dropped and could not be reestablished.
+	EndReasonReconnectFailed = EndReason("reconnect failed")
+)
+
+// Err returns the error matching the reason: ErrMediaTimeout for the media
+// and hold timeouts, nil for the others.
+func (r EndReason) Err() error {
+	switch r {
+	case EndReasonMediaTimeout:
+		return ErrMediaTimeout
+	case EndReasonHoldTimeout:
+		return fmt.Errorf("%w: %s", ErrMediaTimeout, string(r))
+	}
+
+	return nil
+}
+
+// Events receives session lifecycle and room activity notifications.
+// Callbacks run on the goroutine that observed the event and must not block.
+type Events interface {
+	OnSessionStarted(sID string)
+	OnSessionEnded(sID string, reason EndReason)
+
+	OnParticipantJoined(sID, identity string)
+	OnParticipantLeft(sID, identity string)
+
+	OnTrackSubscribed(sID, identity, trackID string)
+	OnTrackUnsubscribed(sID, identity, trackID string)
+
+	// OnReconnecting is called when the connection to the room dropped and
+	// is being reestablished, OnReconnected once it is. The session ends
+	// with EndReasonReconnectFailed if it cannot be.
+	OnReconnecting(sID string)
+	OnReconnected(sID string)
+
+	// OnRTPTimeout is called when no RTP arrived from the peer for the RTP
+	// timeout, see MediaTimeouts. onHold tells if the silence is expected.
+	OnRTPTimeout(sID string, onHold bool)
+	// OnRTCPBye is called when the peer sends an RTCP BYE.
+	OnRTCPBye(sID string, reason string)
+
+	// OnPlaybackFinished is called when a playback started with Play ended,
+	// interrupted by Playback.Stop or not. Playbacks a disconnect stops are
+	// reported before OnSessionEnded, or not at all if they did not stop
+	// before the ctx of DisconnectFromRoom was done.
+	OnPlaybackFinished(sID, playbackID string, interrupted bool)
+	// OnRecordingFinished is called when a recording started with
+	// StartRecording is complete on disk.
+	OnRecordingFinished(sID, path string, duration time.Duration)
+}
+
+// NopEvents ignores every event. Embed it to implement only some of Events.
+type NopEvents struct{}
+
+func (NopEvents) OnSessionStarted(string)                           {}
+func (NopEvents) OnSessionEnded(string, EndReason)                  {}
+func (NopEvents) OnParticipantJoined(string, string)                {}
+func (NopEvents) OnParticipantLeft(string, string)                  {}
+func (NopEvents) OnTrackSubscribed(string, string, string)          {}
+func (NopEvents) OnTrackUnsubscribed(string, string, string)        {}
+func (NopEvents) OnReconnecting(string)                             {}
+func (NopEvents) OnReconnected(string)                              {}
+func (NopEvents) OnRTPTimeout(string, bool)                         {}
+func (NopEvents) OnRTCPBye(string, string)                          {}
+func (NopEvents) OnPlaybackFinished(string, string, bool)           {}
+func (NopEvents) OnRecordingFinished(string, string, time.Duration) {}
+
+// ManagerOption configures optional Manager behaviour.
+type ManagerOption func(*Manager)
+
+// WithEvents delivers session events to events.
+func WithEvents(events Events) ManagerOption {
+	return func(r *Manager) {
+		r.events = events
+	}
+}
@@ -4,20 +4,23 @@ import (
 	"fmt"
 
 	"github.com/livekit/media-sdk/rtp"
+	"github.com/livekit/protocol/logger"
 )
 
 type handlerRTP[T rtp.BytesFrame] struct {
 	streamIn *rtp.MediaStreamIn[T]
+	log      logger.Logger
 }
 
-func newHandlerRTP[T rtp.BytesFrame, Sample *rtp.MediaStreamIn[T]](streamIn Sample) rtp.HandlerCloser {
+func newHandlerRTP[T rtp.BytesFrame, Sample *rtp.MediaStreamIn[T]](streamIn Sample, log logger.Logger) rtp.HandlerCloser {
 	return &handlerRTP[T]{
 		streamIn: streamIn,
+		log:      log,
 	}
 }
 
 func (h *handlerRTP[T]) Close() {
-	fmt.Printf("handleRTP: Closed\n")
+	h.log.Debugw("handleRTP closed")
 }
 
 func (h *handlerRTP[T]) HandleRTP(header *rtp.Header, payload []byte) error {
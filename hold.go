@@ -0,0 +1,189 @@
+package rtp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/livekit/media-sdk"
+	"github.com/livekit/media-sdk/rtp"
+	"github.com/livekit/protocol/logger"
+)
+
+// AudioSource produces PCM played into the RTP leg, such as hold music.
+type AudioSource interface {
+	// SampleRate is the rate of the PCM returned by ReadPCM.
+	SampleRate() int
+	// ReadPCM fills pcm with mono samples and returns how many it wrote. It
+	// returns io.EOF once the source is exhausted.
+	ReadPCM(pcm []int16) (int, error)
+}
+
+// DirectionOption configures SetDirection.
+type DirectionOption func(*directionConfig)
+
+type directionConfig struct {
+	holdMusic AudioSource
+}
+
+// WithHoldMusic plays src to the RTP peer instead of the room while the peer
+// is on hold, that is while the direction is sendonly. Silence follows once
+// src is exhausted.
+func WithHoldMusic(src AudioSource) DirectionOption {
+	return func(c *directionConfig) {
+		c.holdMusic = src
+	}
+}
+
+// sends tells if media flows from the bridge to the RTP peer in direction d.
+func (d Direction) sends() bool {
+	return d == DirectionSendRecv || d == DirectionSendOnly
+}
+
+// receives tells if media flows from the RTP peer into the room in direction d.
+func (d Direction) receives() bool {
+	return d == DirectionSendRecv || d == DirectionRecvOnly
+}
+
+// SetDirection pauses and resumes each direction of the RTP leg, dir being
+// our side of the SDP as in Answer.Direction:
+//   - not sending, the mix is no longer sent to the peer;
+//   - not receiving, the peer is on hold: the published track is muted and
+//     the RTP inactivity timeout is suspended, only the hold timeout applies.
+func (r *Manager) SetDirection(ctx context.Context, sID string, dir Direction, opts ...DirectionOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	switch dir {
+	case DirectionSendRecv, DirectionSendOnly, DirectionRecvOnly, DirectionInactive:
+	default:
+		return fmt.Errorf("session %s: invalid direction %q", sID, dir)
+	}
+
+	session, ok := r.getSession(sID)
+
+	if !ok {
+		return fmt.Errorf("session %s: %w", sID, ErrNotFound)
+	}
+
+	if err := session.expect(opDirection, StateConnected, StateBound, StateActive); err != nil {
+		return err
+	}
+
+	conf := &directionConfig{}
+	for _, opt := range opts {
+		opt(conf)
+	}
+
+	session.log.Infow("setting direction", "direction", dir, "holdMusic", conf.holdMusic != nil)
+
+	session.setDirection(dir, conf.holdMusic)
+
+	return nil
+}
+
+// holdMusic resamples an AudioSource to the rate of the mixer.
+type holdMusic struct {
+	mx        sync.Mutex
+	log       logger.Logger
+	src       AudioSource
+	resampler media.PCM16Writer
+	buf       media.PCM16Sample
+	read      []int16
+	done      bool
+}
+
+func newHoldMusic(src AudioSource, sampleRate int, log logger.Logger) *holdMusic {
+	h := &holdMusic{
+		log:  log,
+		src:  src,
+		read: make([]int16, src.SampleRate()/rtp.DefFramesPerSec),
+	}
+
+	h.resampler = media.ResampleWriter(media.NewPCM16BufferWriter(&h.buf, sampleRate), src.SampleRate())
+
+	return h
+}
+
+// Next returns the following n samples, padded with silence once the source is exhausted.
+func (h *holdMusic) Next(n int) media.PCM16Sample {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	for len(h.buf) < n && !h.done {
+		k, err := h.src.ReadPCM(h.read)
+		if k > 0 {
+			if werr := h.resampler.WriteSample(h.read[:k]); werr != nil {
+				h.log.Warnw("failed to resample hold music", werr)
+
+				h.done = true
+			}
+		}
+
+		if err != nil {
+			h.done = true
+		} else if k == 0 {
+			// nothing to play for now
+			break
+		}
+	}
+
+	out := make(media.PCM16Sample, n)
+	copied := copy(out, h.buf)
+
+	rest := copy(h.buf, h.buf[copied:])
+	h.buf = h.buf[:rest]
+
+	return out
+}
+
+func (h *holdMusic) Close() {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.done = true
+
+	if err := h.resampler.Close(); err != nil {
+		h.log.Warnw("failed to close hold music resampler", err)
+	}
+}
+
+// setDirection records dir and applies it to the current binding.
+func (s *session) setDirection(dir Direction, src AudioSource) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.holdMusic != nil {
+		s.holdMusic.Close()
+		s.holdMusic = nil
+	}
+
+	if src != nil {
+		s.holdMusic = newHoldMusic(src, s.mixerSampleRate, s.log)
+	}
+
+	s.direction = dir
+	s.applyDirectionLocked()
+}
+
+// applyDirectionLocked pauses and resumes the current binding and the
+// published track as the direction says. s.mx must be held.
+func (s *session) applyDirectionLocked() {
+	var music *holdMusic
+	if !s.direction.receives() {
+		music = s.holdMusic
+	}
+
+	if s.mediaWriter != nil {
+		s.mediaWriter.SetSending(s.direction.sends(), music)
+	}
+
+	if s.streamRTP != nil {
+		s.streamRTP.held.Store(!s.direction.receives())
+	}
+
+	if s.publication != nil {
+		s.publication.SetMuted(!s.direction.receives())
+	}
+}
@@ -0,0 +1,213 @@
+package rtp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/livekit/media-sdk/jitter"
+	"github.com/livekit/media-sdk/rtp"
+)
+
+const (
+	// jitterMinLatency and jitterMaxLatency bound how long a packet is held waiting for earlier ones.
+	jitterMinLatency = 20 * time.Millisecond
+	jitterMaxLatency = 200 * time.Millisecond
+
+	// jitterLatencyStep keeps the latency from following every small change of the jitter estimate.
+	jitterLatencyStep = 10 * time.Millisecond
+
+	// jitterDuplicateWindow is how many sequence numbers back duplicates are detected.
+	jitterDuplicateWindow = 64
+)
+
+// jitterBuffer reorders the RTP received from the peer by sequence number
+// before it reaches the rtpSampleProvider. Its latency follows the RFC 3550
+// interarrival jitter, duplicates are dropped, and a new SSRC flushes the
+// packets of the previous one and starts over.
+type jitterBuffer struct {
+	mx sync.Mutex
+
+	buf     *jitter.Buffer
+	latency time.Duration
+	stats   *streamStats
+	closed  bool
+
+	started bool
+	ssrc    uint32
+
+	// duplicate detection, a window of the sequence numbers below maxSeq
+	maxSeq uint16
+	window uint64
+
+	duplicates uint64
+	reported   jitter.BufferStats
+
+	outMx     sync.Mutex
+	outClosed bool
+	out       func(pkt *rtp.Packet)
+}
+
+func newJitterBuffer(stats *streamStats, out func(pkt *rtp.Packet)) *jitterBuffer {
+	b := &jitterBuffer{
+		latency: jitterMinLatency,
+		stats:   stats,
+		out:     out,
+	}
+
+	b.buf = b.newBuffer()
+
+	return b
+}
+
+func (b *jitterBuffer) newBuffer() *jitter.Buffer {
+	return jitter.NewBuffer(audioDepacketizer{}, b.latency, b.emit)
+}
+
+// emit is called by jitter.Buffer, possibly from its timer goroutine.
+func (b *jitterBuffer) emit(packets []jitter.ExtPacket) {
+	b.outMx.Lock()
+	defer b.outMx.Unlock()
+
+	if b.outClosed {
+		return
+	}
+
+	for _, p := range packets {
+		b.out(p.Packet)
+	}
+}
+
+func (b *jitterBuffer) Push(pkt *rtp.Packet, arrival time.Time) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	if !b.started || b.ssrc != pkt.SSRC {
+		if b.started {
+			b.resetLocked()
+		}
+
+		b.started, b.ssrc = true, pkt.SSRC
+		b.maxSeq, b.window = pkt.SequenceNumber, 0
+	}
+
+	if b.duplicateLocked(pkt.SequenceNumber) {
+		b.duplicates++
+		b.reportLocked()
+
+		return
+	}
+
+	b.adaptLocked()
+	b.buf.PushAt(pkt, arrival)
+	b.reportLocked()
+}
+
+// duplicateLocked tells if seq was pushed already and marks it as seen otherwise.
+func (b *jitterBuffer) duplicateLocked(seq uint16) bool {
+	if seqNewer(seq, b.maxSeq) {
+		shift := seq - b.maxSeq
+		if shift >= jitterDuplicateWindow {
+			b.window = 0
+		} else {
+			b.window <<= shift
+		}
+
+		b.maxSeq = seq
+		b.window |= 1
+
+		return false
+	}
+
+	back := b.maxSeq - seq
+	if back >= jitterDuplicateWindow {
+		// too old to tell, the buffer drops it as late anyway
+		return false
+	}
+
+	bit := uint64(1) << back
+	if b.window&bit != 0 {
+		return true
+	}
+
+	b.window |= bit
+
+	return false
+}
+
+// adaptLocked sets the latency to cover three times the interarrival jitter.
+func (b *jitterBuffer) adaptLocked() {
+	latency := jitterMinLatency + 3*b.stats.Jitter()
+	latency = min(latency.Round(jitterLatencyStep), jitterMaxLatency)
+
+	if latency == b.latency {
+		return
+	}
+
+	b.latency = latency
+	b.buf.UpdateLatency(latency)
+}
+
+// resetLocked hands out what is buffered for the previous SSRC and starts a fresh buffer.
+func (b *jitterBuffer) resetLocked() {
+	b.buf.Flush()
+	b.reportLocked()
+	b.buf.Close()
+
+	b.buf = b.newBuffer()
+	b.reported = jitter.BufferStats{}
+}
+
+func (b *jitterBuffer) reportLocked() {
+	s := b.buf.Stats()
+
+	b.stats.OnJitterBuffer(
+		b.buf.Size(),
+		b.latency,
+		b.duplicates,
+		s.PacketsDropped-b.reported.PacketsDropped,
+		s.PacketsLost-b.reported.PacketsLost,
+	)
+
+	b.duplicates = 0
+	b.reported = *s
+}
+
+// Close drops what is still buffered. out is not called once Close returns.
+func (b *jitterBuffer) Close() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.closed = true
+
+	b.outMx.Lock()
+	b.outClosed = true
+	b.outMx.Unlock()
+
+	b.reportLocked()
+	b.buf.Close()
+
+	b.stats.OnJitterBuffer(0, b.latency, 0, 0, 0)
+}
+
+// audioDepacketizer treats every audio packet as a complete frame.
+type audioDepacketizer struct{}
+
+func (audioDepacketizer) Unmarshal(packet []byte) ([]byte, error) {
+	return packet, nil
+}
+
+func (audioDepacketizer) IsPartitionHead([]byte) bool {
+	return true
+}
+
+func (audioDepacketizer) IsPartitionTail(bool, []byte) bool {
+	return true
+}
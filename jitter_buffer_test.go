@@ -0,0 +1,134 @@
+package rtp
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livekit/media-sdk/rtp"
+)
+
+func TestJitterBufferDuplicates(t *testing.T) {
+	cases := []struct {
+		name string
+		seqs []uint16
+		dups []bool
+	}{
+		{
+			name: "in order",
+			seqs: []uint16{1, 2, 3, 4},
+			dups: []bool{false, false, false, false},
+		},
+		{
+			name: "repeated",
+			seqs: []uint16{1, 2, 2, 3, 1},
+			dups: []bool{false, false, true, false, true},
+		},
+		{
+			name: "reordered",
+			seqs: []uint16{1, 3, 2, 3, 2},
+			dups: []bool{false, false, false, true, true},
+		},
+		{
+			name: "wraparound",
+			seqs: []uint16{65534, 65535, 0, 65535, 1, 0},
+			dups: []bool{false, false, false, true, false, true},
+		},
+		{
+			name: "outside the window",
+			seqs: []uint16{10, 10 + jitterDuplicateWindow, 10, 11, 11},
+			dups: []bool{false, false, false, false, true},
+		},
+		{
+			name: "late within the window",
+			seqs: []uint16{10, 20, 15, 15, 20},
+			dups: []bool{false, false, false, true, true},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &jitterBuffer{maxSeq: tc.seqs[0]}
+
+			for i, seq := range tc.seqs {
+				if got := b.duplicateLocked(seq); got != tc.dups[i] {
+					t.Errorf("packet %d (seq %d): duplicate = %v, want %v", i, seq, got, tc.dups[i])
+				}
+			}
+		})
+	}
+}
+
+func TestJitterBufferSSRCReset(t *testing.T) {
+	var (
+		mx  sync.Mutex
+		out []*rtp.Packet
+	)
+
+	stats := newStreamStats(1, "test", nil)
+
+	b := newJitterBuffer(stats, func(pkt *rtp.Packet) {
+		mx.Lock()
+		defer mx.Unlock()
+
+		out = append(out, pkt)
+	})
+	defer b.Close()
+
+	now := time.Now()
+	packet := func(ssrc uint32, seq uint16) *rtp.Packet {
+		return &rtp.Packet{Header: rtp.Header{SSRC: ssrc, SequenceNumber: seq, Timestamp: uint32(seq) * 160}}
+	}
+
+	// 3 is held back waiting for 2 when the SSRC changes
+	b.Push(packet(1, 1), now)
+	b.Push(packet(1, 3), now)
+	b.Push(packet(2, 1), now)
+
+	mx.Lock()
+	var fromFirst []uint16
+	for _, pkt := range out {
+		if pkt.SSRC == 1 {
+			fromFirst = append(fromFirst, pkt.SequenceNumber)
+		}
+	}
+	mx.Unlock()
+
+	if len(fromFirst) != 2 || fromFirst[0] != 1 || fromFirst[1] != 3 {
+		t.Errorf("packets of the previous SSRC = %v, want [1 3] flushed", fromFirst)
+	}
+
+	if got := stats.jitterBuffer.Duplicates; got != 0 {
+		t.Errorf("duplicates = %d, want 0: sequence numbers of a new SSRC start over", got)
+	}
+}
+
+func TestJitterBufferLatency(t *testing.T) {
+	cases := []struct {
+		name   string
+		jitter time.Duration
+		want   time.Duration
+	}{
+		{name: "no jitter", jitter: 0, want: jitterMinLatency},
+		{name: "small jitter rounds", jitter: 3 * time.Millisecond, want: 30 * time.Millisecond},
+		{name: "follows jitter", jitter: 10 * time.Millisecond, want: 50 * time.Millisecond},
+		{name: "capped", jitter: time.Second, want: jitterMaxLatency},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stats := newStreamStats(1, "test", nil)
+			stats.SetClockRate(8000)
+			stats.jitter = tc.jitter.Seconds() * 8000
+
+			b := newJitterBuffer(stats, func(*rtp.Packet) {})
+			defer b.Close()
+
+			b.Push(&rtp.Packet{Header: rtp.Header{SSRC: 1, SequenceNumber: 1}}, time.Now())
+
+			if b.latency != tc.want {
+				t.Errorf("latency = %v, want %v", b.latency, tc.want)
+			}
+		})
+	}
+}
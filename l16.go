@@ -0,0 +1,85 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/livekit/media-sdk"
+)
+
+// L16 is uncompressed 16-bit PCM in network byte order, RFC 3551 4.5.11.
+// The clock rate is the sample rate. The RTP leg mixes mono, so stereo L16
+// is upmixed on the way out and downmixed on the way in.
+
+type l16Encoder struct {
+	w        PayloadWriter
+	channels int
+	buf      []byte
+}
+
+func encodeL16(w PayloadWriter, channels int) media.PCM16Writer {
+	return &l16Encoder{w: w, channels: channels}
+}
+
+func (e *l16Encoder) String() string {
+	return fmt.Sprintf("L16Encode(%d) -> %s", e.channels, e.w.String())
+}
+
+func (e *l16Encoder) SampleRate() int {
+	return e.w.SampleRate()
+}
+
+func (e *l16Encoder) WriteSample(sample media.PCM16Sample) error {
+	e.buf = e.buf[:0]
+
+	for _, v := range sample {
+		for range e.channels {
+			e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v))
+		}
+	}
+
+	return e.w.WriteSample(e.buf)
+}
+
+func (e *l16Encoder) Close() error {
+	return e.w.Close()
+}
+
+type l16Decoder struct {
+	w        media.PCM16Writer
+	channels int
+	buf      media.PCM16Sample
+}
+
+func decodeL16(w media.PCM16Writer, channels int) PayloadWriter {
+	return &l16Decoder{w: w, channels: channels}
+}
+
+func (d *l16Decoder) String() string {
+	return fmt.Sprintf("L16Decode(%d) -> %s", d.channels, d.w.String())
+}
+
+func (d *l16Decoder) SampleRate() int {
+	return d.w.SampleRate()
+}
+
+func (d *l16Decoder) WriteSample(payload []byte) error {
+	frame := 2 * d.channels
+	d.buf = d.buf[:0]
+
+	for ; len(payload) >= frame; payload = payload[frame:] {
+		var sum int
+
+		for ch := range d.channels {
+			sum += int(int16(binary.BigEndian.Uint16(payload[2*ch:])))
+		}
+
+		d.buf = append(d.buf, int16(sum/d.channels))
+	}
+
+	return d.w.WriteSample(d.buf)
+}
+
+func (d *l16Decoder) Close() error {
+	return d.w.Close()
+}
@@ -0,0 +1,144 @@
+package rtp
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/livekit/media-sdk"
+)
+
+// payloadBuffer keeps the payloads written to it.
+type payloadBuffer struct {
+	sampleRate int
+	payloads   [][]byte
+}
+
+func (b *payloadBuffer) String() string {
+	return "payloadBuffer"
+}
+
+func (b *payloadBuffer) SampleRate() int {
+	return b.sampleRate
+}
+
+func (b *payloadBuffer) WriteSample(payload []byte) error {
+	b.payloads = append(b.payloads, slices.Clone(payload))
+
+	return nil
+}
+
+func (b *payloadBuffer) Close() error {
+	return nil
+}
+
+func TestL16Encode(t *testing.T) {
+	cases := []struct {
+		name     string
+		channels int
+		pcm      media.PCM16Sample
+		want     []byte
+	}{
+		{
+			name:     "mono in network byte order",
+			channels: 1,
+			pcm:      media.PCM16Sample{1, -2, 0x1234},
+			want:     []byte{0x00, 0x01, 0xff, 0xfe, 0x12, 0x34},
+		},
+		{
+			name:     "stereo upmix",
+			channels: 2,
+			pcm:      media.PCM16Sample{1, -32768},
+			want:     []byte{0x00, 0x01, 0x00, 0x01, 0x80, 0x00, 0x80, 0x00},
+		},
+		{
+			name:     "empty",
+			channels: 2,
+			pcm:      media.PCM16Sample{},
+			want:     []byte{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &payloadBuffer{sampleRate: 8000}
+
+			if err := encodeL16(out, tc.channels).WriteSample(tc.pcm); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(out.payloads) != 1 || !slices.Equal(out.payloads[0], tc.want) {
+				t.Errorf("payloads = %x, want [%x]", out.payloads, tc.want)
+			}
+		})
+	}
+}
+
+func TestL16Decode(t *testing.T) {
+	cases := []struct {
+		name     string
+		channels int
+		payload  []byte
+		want     media.PCM16Sample
+	}{
+		{
+			name:     "mono",
+			channels: 1,
+			payload:  []byte{0x00, 0x01, 0xff, 0xfe, 0x12, 0x34},
+			want:     media.PCM16Sample{1, -2, 0x1234},
+		},
+		{
+			name:     "stereo downmix",
+			channels: 2,
+			payload:  []byte{0x00, 0x64, 0x00, 0xc8, 0xff, 0x9c, 0x00, 0x64},
+			want:     media.PCM16Sample{150, 0},
+		},
+		{
+			name:     "stereo downmix at full scale",
+			channels: 2,
+			payload:  []byte{0x7f, 0xff, 0x7f, 0xff, 0x80, 0x00, 0x80, 0x00},
+			want:     media.PCM16Sample{32767, -32768},
+		},
+		{
+			name:     "partial frame dropped",
+			channels: 2,
+			payload:  []byte{0x00, 0x02, 0x00, 0x04, 0x00, 0x01},
+			want:     media.PCM16Sample{3},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out media.PCM16Sample
+
+			if err := decodeL16(media.NewPCM16BufferWriter(&out, 8000), tc.channels).WriteSample(tc.payload); err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(out, tc.want) {
+				t.Errorf("pcm = %v, want %v", out, tc.want)
+			}
+		})
+	}
+}
+
+func TestL16RoundTrip(t *testing.T) {
+	for _, channels := range []int{1, 2} {
+		pcm := media.PCM16Sample{0, 1, -1, 32767, -32768, 1000}
+
+		payloads := &payloadBuffer{sampleRate: 48000}
+		if err := encodeL16(payloads, channels).WriteSample(pcm); err != nil {
+			t.Fatal(err)
+		}
+
+		var out media.PCM16Sample
+
+		decoder := decodeL16(media.NewPCM16BufferWriter(&out, 48000), channels)
+		if err := decoder.WriteSample(payloads.payloads[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(out, pcm) {
+			t.Errorf("%d channels: pcm = %v, want %v", channels, out, pcm)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package rtp
+
+import (
+	"context"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+// await runs fn in the background and waits for it or for ctx, as the
+// LiveKit SDK takes no context. When ctx is done first, the result fn
+// eventually returns is handed to cleanup, if it succeeded.
+func await[T any](ctx context.Context, fn func() (T, error), cleanup func(T)) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+
+	done := make(chan result, 1)
+
+	go func() {
+		v, err := fn()
+		done <- result{v, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.v, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-done; res.err == nil && cleanup != nil {
+				cleanup(res.v)
+			}
+		}()
+
+		var zero T
+
+		return zero, ctx.Err()
+	}
+}
+
+// connectRoom joins a room like lksdk.ConnectToRoom until ctx is done. A
+// join that completes after that is disconnected again.
+func connectRoom(ctx context.Context, url string, info lksdk.ConnectInfo, cb *lksdk.RoomCallback) (*lksdk.Room, error) {
+	return await(ctx, func() (*lksdk.Room, error) {
+		return lksdk.ConnectToRoom(url, info, cb)
+	}, func(room *lksdk.Room) {
+		room.Disconnect()
+	})
+}
+
+// leaveRoom disconnects from room, waiting for it until ctx is done.
+func leaveRoom(ctx context.Context, room *lksdk.Room) error {
+	_, err := await(ctx, func() (struct{}, error) {
+		room.Disconnect()
+
+		return struct{}{}, nil
+	}, nil)
+
+	return err
+}
+
+// publishTrack publishes track like PublishTrack until ctx is done. A
+// publication that completes after that is unpublished again.
+func publishTrack(
+	ctx context.Context,
+	lp *lksdk.LocalParticipant,
+	track *lksdk.LocalTrack,
+	opts *lksdk.TrackPublicationOptions,
+) (*lksdk.LocalTrackPublication, error) {
+	return await(ctx, func() (*lksdk.LocalTrackPublication, error) {
+		return lp.PublishTrack(track, opts)
+	}, func(publication *lksdk.LocalTrackPublication) {
+		_ = lp.UnpublishTrack(publication.SID())
+	})
+}
@@ -0,0 +1,49 @@
+package rtp
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/livekit/protocol/logger"
+)
+
+// logInterval is how often a message the peer can trigger with every packet
+// is logged at most.
+const logInterval = 10 * time.Second
+
+// WithLogger sets the logger of the Manager, logger.GetLogger() by default.
+// Messages carry the session, room and identity they are about; an slog
+// handler is used with logger.LogRLogger(logr.FromSlogHandler(handler)).
+func WithLogger(log logger.Logger) ManagerOption {
+	return func(r *Manager) {
+		r.log = log
+	}
+}
+
+// logLimit rate limits a message logged per packet. The messages it holds
+// back are counted in the next one that gets through.
+type logLimit struct {
+	next       atomic.Int64
+	suppressed atomic.Int64
+}
+
+// allow tells if the message may be logged now, and how many were held back since the last one.
+func (l *logLimit) allow() (bool, int64) {
+	now := time.Now().UnixNano()
+
+	next := l.next.Load()
+	if now < next || !l.next.CompareAndSwap(next, now+int64(logInterval)) {
+		l.suppressed.Add(1)
+
+		return false, 0
+	}
+
+	return true, l.suppressed.Swap(0)
+}
+
+// Debugw logs at debug level unless the message was logged within logInterval.
+func (l *logLimit) Debugw(log logger.Logger, msg string, keysAndValues ...any) {
+	if ok, suppressed := l.allow(); ok {
+		log.Debugw(msg, append(keysAndValues, "suppressed", suppressed)...)
+	}
+}
@@ -0,0 +1,161 @@
+package rtp
+
+import (
+	"time"
+)
+
+const (
+	// mediaWatchInterval is how often streamRTP checks the peer for inactivity.
+	mediaWatchInterval = time.Second
+
+	defaultRTPTimeout  = time.Minute
+	defaultRTCPTimeout = 5 * rtcpInterval
+)
+
+// MediaTimeouts bounds how long the RTP peer may stay silent before the
+// session is torn down.
+type MediaTimeouts struct {
+	// RTP is how long no RTP may arrive, 0 to never time out.
+	RTP time.Duration
+	// RTCP is how long no RTCP may arrive once the peer sent some, 0 to
+	// never time out. It only counts while no RTP arrives either, for the
+	// RTP timeout or, without one, for as long: peers that send RTP but no
+	// RTCP are alive. A peer that stopped sending RTP but keeps sending
+	// RTCP is on hold, one that stopped both is dead media.
+	RTCP time.Duration
+	// Hold is how long no RTP may arrive while on hold, seen from RTCP or
+	// set by SetDirection, 0 to wait forever.
+	Hold time.Duration
+}
+
+func defaultMediaTimeouts() MediaTimeouts {
+	return MediaTimeouts{
+		RTP:  defaultRTPTimeout,
+		RTCP: defaultRTCPTimeout,
+	}
+}
+
+// WithMediaTimeouts sets the inactivity timeouts of the RTP leg. When they
+// pass, the session is disconnected as by DisconnectFromRoom and
+// OnSessionEnded reports EndReasonMediaTimeout or EndReasonHoldTimeout.
+func WithMediaTimeouts(timeouts MediaTimeouts) ManagerOption {
+	return func(r *Manager) {
+		r.timeouts = timeouts
+	}
+}
+
+// watchMedia watches the peer for inactivity until the stream is closed.
+func (c *streamRTP) watchMedia() {
+	if c.timeouts.RTP <= 0 && c.timeouts.RTCP <= 0 && c.timeouts.Hold <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(mediaWatchInterval)
+	defer ticker.Stop()
+
+	timedOut := false
+
+	for {
+		var now time.Time
+
+		select {
+		case <-c.done:
+			return
+		case now = <-ticker.C:
+		}
+
+		idle := now.Sub(time.Unix(0, c.lastRTP.Load()))
+
+		// silence is expected on hold, only the hold timeout applies
+		if c.held.Load() {
+			timedOut = false
+
+			if c.timeouts.Hold <= 0 || idle < c.timeouts.Hold {
+				continue
+			}
+
+			if !c.closed.Load() {
+				c.onDead(EndReasonHoldTimeout)
+			}
+
+			return
+		}
+
+		// RTCP goes on during hold too, RFC 3550 6.2
+		if rtcpIdle, ok := c.rtcpTimedOut(now, idle); ok {
+			c.log.Infow("no RTP nor RTCP from the peer", "idle", rtcpIdle.Round(time.Second), "remoteAddr", c.remoteAddr())
+
+			if !c.closed.Load() {
+				c.onDead(EndReasonMediaTimeout)
+			}
+
+			return
+		}
+
+		if c.timeouts.RTP <= 0 || idle < c.timeouts.RTP {
+			timedOut = false
+
+			continue
+		}
+
+		onHold := c.onHold(now)
+
+		if !timedOut {
+			timedOut = true
+
+			c.log.Infow("no RTP from the peer", "idle", idle.Round(time.Second), "onHold", onHold, "remoteAddr", c.remoteAddr())
+
+			c.onTimeout(onHold)
+		}
+
+		var reason EndReason
+
+		switch {
+		case !onHold:
+			reason = EndReasonMediaTimeout
+		case c.timeouts.Hold > 0 && idle >= c.timeouts.Hold:
+			reason = EndReasonHoldTimeout
+		default:
+			continue
+		}
+
+		// a rebind detached the stream in the meantime, the new one watches now
+		if c.closed.Load() {
+			return
+		}
+
+		c.onDead(reason)
+
+		return
+	}
+}
+
+// rtcpTimedOut tells if the peer stopped sending RTCP for the RTCP timeout
+// while it sent no RTP for rtpIdle either, and for how long.
+func (c *streamRTP) rtcpTimedOut(now time.Time, rtpIdle time.Duration) (time.Duration, bool) {
+	last := c.lastRTCP.Load()
+	if c.timeouts.RTCP <= 0 || last == 0 {
+		return 0, false
+	}
+
+	rtpTimeout := c.timeouts.RTP
+	if rtpTimeout <= 0 {
+		rtpTimeout = c.timeouts.RTCP
+	}
+
+	idle := now.Sub(time.Unix(0, last))
+
+	return idle, idle >= c.timeouts.RTCP && rtpIdle >= rtpTimeout
+}
+
+// onHold tells if the peer still sends RTCP, so its silence is expected.
+func (c *streamRTP) onHold(now time.Time) bool {
+	last := c.lastRTCP.Load()
+
+	window := c.timeouts.RTCP
+	if window <= 0 {
+		window = defaultRTCPTimeout
+	}
+
+	return last != 0 && now.Sub(time.Unix(0, last)) < window
+}
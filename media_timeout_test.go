@@ -0,0 +1,171 @@
+package rtp
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/livekit/protocol/logger"
+)
+
+// feed stores the current time into last until done is closed, as packets
+// arriving from the peer do.
+func feed(last *atomic.Int64, done <-chan struct{}) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		last.Store(time.Now().UnixNano())
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func TestWatchMedia(t *testing.T) {
+	cases := []struct {
+		name        string
+		timeouts    MediaTimeouts
+		rtp, rtcp   bool
+		rtcpStopped bool // the peer sent RTCP once, at the start
+		held        bool
+		wantTimeout []bool
+		wantDead    EndReason
+	}{
+		{
+			name:        "no RTP",
+			timeouts:    MediaTimeouts{RTP: 1500 * time.Millisecond},
+			wantTimeout: []bool{false},
+			wantDead:    EndReasonMediaTimeout,
+		},
+		{
+			name:     "RTP flowing",
+			timeouts: MediaTimeouts{RTP: 1500 * time.Millisecond, RTCP: 1500 * time.Millisecond},
+			rtp:      true,
+			rtcp:     true,
+		},
+		{
+			name:        "RTP without RTCP",
+			timeouts:    MediaTimeouts{RTP: 1500 * time.Millisecond, RTCP: 1500 * time.Millisecond},
+			rtp:         true,
+			rtcpStopped: true,
+		},
+		{
+			name:        "no RTP nor RTCP",
+			timeouts:    MediaTimeouts{RTP: 1500 * time.Millisecond, RTCP: 1500 * time.Millisecond},
+			rtcpStopped: true,
+			wantDead:    EndReasonMediaTimeout,
+		},
+		{
+			name:        "no RTCP while held",
+			timeouts:    MediaTimeouts{RTP: 1500 * time.Millisecond, RTCP: 1500 * time.Millisecond},
+			rtcpStopped: true,
+			held:        true,
+		},
+		{
+			name:        "peer on hold",
+			timeouts:    MediaTimeouts{RTP: 1500 * time.Millisecond, Hold: 2500 * time.Millisecond},
+			rtcp:        true,
+			wantTimeout: []bool{true},
+			wantDead:    EndReasonHoldTimeout,
+		},
+		{
+			name:        "peer on hold forever",
+			timeouts:    MediaTimeouts{RTP: 1500 * time.Millisecond},
+			rtcp:        true,
+			wantTimeout: []bool{true},
+		},
+		{
+			name:     "held by us",
+			timeouts: MediaTimeouts{RTP: 1500 * time.Millisecond, Hold: 2500 * time.Millisecond},
+			held:     true,
+			wantDead: EndReasonHoldTimeout,
+		},
+		{
+			name:     "no timeouts",
+			timeouts: MediaTimeouts{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mx       sync.Mutex
+				timeouts []bool
+				dead     []EndReason
+			)
+
+			c := &streamRTP{
+				log:      logger.GetLogger(),
+				done:     make(chan struct{}),
+				timeouts: tc.timeouts,
+				onTimeout: func(onHold bool) {
+					mx.Lock()
+					defer mx.Unlock()
+
+					timeouts = append(timeouts, onHold)
+				},
+				onDead: func(reason EndReason) {
+					mx.Lock()
+					defer mx.Unlock()
+
+					dead = append(dead, reason)
+				},
+			}
+
+			c.lastRTP.Store(time.Now().UnixNano())
+
+			if tc.rtcpStopped {
+				c.lastRTCP.Store(time.Now().UnixNano())
+			}
+			c.held.Store(tc.held)
+
+			if tc.rtp {
+				go feed(&c.lastRTP, c.done)
+			}
+
+			if tc.rtcp {
+				go feed(&c.lastRTCP, c.done)
+			}
+
+			stopped := make(chan struct{})
+
+			go func() {
+				defer close(stopped)
+
+				c.watchMedia()
+			}()
+
+			select {
+			case <-stopped:
+			case <-time.After(3500 * time.Millisecond):
+			}
+
+			close(c.done)
+			<-stopped
+
+			mx.Lock()
+			defer mx.Unlock()
+
+			if !slices.Equal(timeouts, tc.wantTimeout) {
+				t.Errorf("onTimeout called with %v, want %v", timeouts, tc.wantTimeout)
+			}
+
+			var wantDead []EndReason
+			if tc.wantDead != "" {
+				wantDead = []EndReason{tc.wantDead}
+			}
+
+			if !slices.Equal(dead, wantDead) {
+				t.Errorf("onDead called with %v, want %v", dead, wantDead)
+			}
+		})
+	}
+}
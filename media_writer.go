@@ -2,29 +2,20 @@ package rtp
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/livekit/media-sdk"
-	"github.com/livekit/media-sdk/g711"
-	"github.com/livekit/media-sdk/opus"
 	"github.com/livekit/media-sdk/rtp"
-	"github.com/livekit/protocol/logger"
 )
 
-type rtpWriteSample[
-	S g711.ALawSample |
-		g711.ULawSample |
-		opus.Sample,
-] struct {
+type rtpWriteSample[S ~[]byte] struct {
 	rtpWriter *rtp.Stream
 	clockRate int
 	marker    bool
 }
 
-func newRTPWriteSample[
-	S g711.ALawSample |
-		g711.ULawSample |
-		opus.Sample,
-](clockRate int, rtpWriter *rtp.Stream) *rtpWriteSample[S] {
+func newRTPWriteSample[S ~[]byte](clockRate int, rtpWriter *rtp.Stream) *rtpWriteSample[S] {
 	return &rtpWriteSample[S]{
 		rtpWriter: rtpWriter,
 		clockRate: clockRate,
@@ -54,53 +45,127 @@ func (w *rtpWriteSample[S]) WriteSample(sample S) error {
 	return nil
 }
 
+// mediaWriter encodes the mix for the RTP peer. It accepts PCM at sampleRate,
+// the rate of the mixer, and resamples it to the clock rate of the codec.
+// With dtx set, silence is sent as comfort noise.
 type mediaWriter[Writer media.Writer[media.PCM16Sample]] struct {
-	encoder   media.PCM16Writer
-	clockRate int
+	encoder    media.PCM16Writer
+	payloads   *rtpWriteSample[[]byte]
+	rtpWriter  *rtp.Stream
+	sampleRate int
+	clockRate  int
+	dtx        *silenceSuppressor
+
+	// paused and music follow the direction of the session, see SetDirection
+	mx     sync.Mutex
+	paused bool
+	music  *holdMusic
+
+	recording *atomic.Pointer[Recording]
 }
 
-func newMediaWriter(seqWriter *rtp.SeqWriter, payloadType byte, clockRate, channels, ptime int) (*mediaWriter[media.Writer[media.PCM16Sample]], error) {
-	rtpWriter := seqWriter.NewStreamWithDur(payloadType, uint32(clockRate*ptime/1000))
+// newMediaWriter returns the writer for codec. cnType is the payload type of
+// comfort noise, 0 to send silence as audio.
+func newMediaWriter(seqWriter *rtp.SeqWriter, codec Codec, ptime, sampleRate int, cnType byte) (*mediaWriter[media.Writer[media.PCM16Sample]], error) {
+	factory, ok := lookupCodec(codec.Name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCodec, codec)
+	}
 
-	var encoder media.PCM16Writer
+	rtpWriter := seqWriter.NewStreamWithDur(codec.PayloadType, uint32(codec.ClockRate*ptime/1000))
 
-	switch payloadType {
-	case PayloadTypePCMA:
-		encoder = g711.EncodeALaw(newRTPWriteSample[g711.ALawSample](clockRate, rtpWriter))
-	case PayloadTypePCMU:
-		encoder = g711.EncodeULaw(newRTPWriteSample[g711.ULawSample](clockRate, rtpWriter))
-	default:
-		if !(PayloadTypeDynamicStart <= payloadType && payloadType <= PayloadTypeDynamicEnd) {
-			return nil, fmt.Errorf("unsupported payload type: %d", payloadType)
-		}
+	payloads := newRTPWriteSample[[]byte](factory.sampleRate(codec), rtpWriter)
 
-		opusEncoder, err := opus.Encode(
-			newRTPWriteSample[opus.Sample](clockRate, rtpWriter),
-			channels, logger.GetLogger(),
-		)
+	encoder, err := factory.NewEncoder(codec, payloads)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %s encoder: %w", codec.Name, err)
+	}
 
-		if err != nil {
-			return nil, fmt.Errorf("cannot create opus encoder: %w", err)
-		}
+	m := &mediaWriter[media.Writer[media.PCM16Sample]]{
+		encoder:    media.ResampleWriter(encoder, sampleRate),
+		payloads:   payloads,
+		rtpWriter:  rtpWriter,
+		sampleRate: sampleRate,
+		clockRate:  codec.ClockRate,
+	}
 
-		encoder = opusEncoder
+	if cnType != 0 {
+		m.dtx = newSilenceSuppressor(seqWriter.NewStreamWithDur(cnType, uint32(codec.ClockRate*ptime/1000)))
 	}
 
-	return &mediaWriter[media.Writer[media.PCM16Sample]]{
-		encoder:   encoder,
-		clockRate: clockRate,
-	}, nil
+	return m, nil
+}
+
+// skip keeps the RTP clock running through a frame that is not sent.
+func (m *mediaWriter[Writer]) skip(sample media.PCM16Sample) {
+	m.rtpWriter.Delay(uint32(len(sample) * m.clockRate / m.sampleRate))
 }
 
 func (m *mediaWriter[Writer]) SampleRate() int {
-	return m.clockRate
+	return m.sampleRate
+}
+
+// ContinueFrom makes the RTP timestamps of m pick up where prev stopped.
+func (m *mediaWriter[Writer]) ContinueFrom(prev *mediaWriter[Writer]) {
+	if prev == nil {
+		return
+	}
+
+	m.rtpWriter.ResetTimestamp(prev.rtpWriter.GetCurrentTimestamp())
 }
 
 func (m *mediaWriter[Writer]) String() string {
 	return "custom media writer"
 }
 
+// SetSending pauses or resumes sending to the peer. When music is set, it
+// is sent instead of the mix.
+func (m *mediaWriter[Writer]) SetSending(send bool, music *holdMusic) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.paused, m.music = !send, music
+}
+
 func (m *mediaWriter[Writer]) WriteSample(sample media.PCM16Sample) error {
+	m.mx.Lock()
+	paused, music := m.paused, m.music
+	m.mx.Unlock()
+
+	if paused {
+		m.skip(sample)
+
+		return nil
+	}
+
+	if music != nil {
+		sample = music.Next(len(sample))
+	}
+
+	if m.recording != nil {
+		if rec := m.recording.Load(); rec != nil {
+			rec.writeRoom(sample)
+		}
+	}
+
+	if m.dtx != nil {
+		voiced, talkspurt, err := m.dtx.Voiced(sample, m.rtpWriter.GetCurrentTimestamp())
+		if err != nil {
+			return fmt.Errorf("custom media writer: failed to write comfort noise: %w", err)
+		}
+
+		if !voiced {
+			m.skip(sample)
+
+			return nil
+		}
+
+		// the first packet of a talkspurt is marked, RFC 3551 4.1
+		if talkspurt {
+			m.payloads.marker = true
+		}
+	}
+
 	if err := m.encoder.WriteSample(sample); err != nil {
 		return fmt.Errorf("custom media writer: failed to write sample: %w", err)
 	}
@@ -0,0 +1,343 @@
+package rtp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/livekit/media-sdk/mixer"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "livekit_rtp"
+
+// Directions of the RTP metrics, seen from the bridge.
+const (
+	dirIn  = "in"
+	dirOut = "out"
+)
+
+// WithMetrics exposes Prometheus metrics of the Manager on reg. Counters of
+// the RTP leg and of the mixer add up all sessions, ended ones included.
+// Without it no metrics are kept.
+func WithMetrics(reg prometheus.Registerer) ManagerOption {
+	return func(r *Manager) {
+		m := newMetrics()
+
+		if err := m.register(reg); err != nil {
+			r.log.Warnw("failed to register metrics", err)
+
+			return
+		}
+
+		r.metrics = m
+	}
+}
+
+// metrics are the Prometheus metrics of a Manager. A nil *metrics records nothing.
+type metrics struct {
+	sessionsActive  prometheus.Gauge
+	sessionsStarted prometheus.Counter
+	sessionsEnded   *prometheus.CounterVec
+	failures        *prometheus.CounterVec
+	duration        *prometheus.HistogramVec
+
+	rtpPackets      *prometheus.CounterVec
+	rtpBytes        *prometheus.CounterVec
+	rtpLost         *prometheus.CounterVec
+	rtpJitter       *prometheus.HistogramVec
+	unexpectedTypes prometheus.Counter
+	rtcpPackets     *prometheus.CounterVec
+
+	mixer *mixerCollector
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		sessionsActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "sessions_active",
+			Help:      "Sessions connected to a room.",
+		}),
+		sessionsStarted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sessions_started_total",
+			Help:      "Sessions created by ConnectToRoom.",
+		}),
+		sessionsEnded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sessions_ended_total",
+			Help:      "Sessions ended, by end reason.",
+		}, []string{"reason"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_failures_total",
+			Help:      "Failed operations: connect, bind, rebind or ack, by reason.",
+		}, []string{"operation", "reason"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of the connect, bind, rebind and ack operations.",
+			Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}, []string{"operation"}),
+
+		rtpPackets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rtp_packets_total",
+			Help:      "RTP packets received from (in) and sent to (out) the peer.",
+		}, []string{"direction"}),
+		rtpBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rtp_payload_bytes_total",
+			Help:      "RTP payload bytes received from (in) and sent to (out) the peer.",
+		}, []string{"direction"}),
+		rtpLost: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rtp_packets_lost_total",
+			Help:      "RTP packets lost from the peer (in) and, as reported by RTCP, to the peer (out).",
+		}, []string{"direction"}),
+		rtpJitter: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "rtp_jitter_seconds",
+			Help:      "Interarrival jitter of the RTP from the peer (in) and, as reported by RTCP, to the peer (out).",
+			Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.02, 0.04, 0.08, 0.16, 0.32},
+		}, []string{"direction"}),
+		unexpectedTypes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rtp_unexpected_payload_type_total",
+			Help:      "RTP packets from the peer dropped for a payload type that was not negotiated.",
+		}),
+		rtcpPackets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rtcp_packets_total",
+			Help:      "RTCP packets received from (in) and sent to (out) the peer.",
+		}, []string{"direction"}),
+
+		mixer: newMixerCollector(),
+	}
+}
+
+func (m *metrics) register(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{
+		m.sessionsActive, m.sessionsStarted, m.sessionsEnded, m.failures, m.duration,
+		m.rtpPackets, m.rtpBytes, m.rtpLost, m.rtpJitter, m.unexpectedTypes, m.rtcpPackets,
+		m.mixer,
+	} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// observe records the duration of op started at startedAt, and its failure if err is set.
+func (m *metrics) observe(op string, startedAt time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	m.duration.WithLabelValues(op).Observe(time.Since(startedAt).Seconds())
+
+	if err != nil {
+		m.failures.WithLabelValues(op, failureReason(err)).Inc()
+	}
+}
+
+// failureReasons label the failures, the first error matched wins.
+var failureReasons = []struct {
+	err    error
+	reason string
+}{
+	{context.DeadlineExceeded, "timeout"},
+	{lksdk.ErrConnectionTimeout, "timeout"},
+	{ErrMediaTimeout, "timeout"},
+	{context.Canceled, "canceled"},
+	{ErrUnsupportedCodec, "unsupported_codec"},
+	{ErrRoomConnectFailed, "room_connect_failed"},
+	{ErrPublishFailed, "publish_failed"},
+	{ErrNotFound, "not_found"},
+	{ErrSessionClosed, "session_closed"},
+	{ErrInvalidState, "invalid_state"},
+}
+
+// failureReason maps err to a label of few values, "other" if it is not
+// one of the errors of the Manager.
+func failureReason(err error) string {
+	for _, r := range failureReasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+
+	return "other"
+}
+
+func (m *metrics) sessionStarted(stats *mixer.Stats) {
+	if m == nil {
+		return
+	}
+
+	m.sessionsStarted.Inc()
+	m.sessionsActive.Inc()
+	m.mixer.add(stats)
+}
+
+func (m *metrics) sessionEnded(stats *mixer.Stats, reason EndReason) {
+	if m == nil {
+		return
+	}
+
+	m.sessionsEnded.WithLabelValues(string(reason)).Inc()
+	m.sessionsActive.Dec()
+	m.mixer.remove(stats)
+}
+
+func (m *metrics) rtp(direction string, payloadSize int) {
+	if m == nil {
+		return
+	}
+
+	m.rtpPackets.WithLabelValues(direction).Inc()
+	m.rtpBytes.WithLabelValues(direction).Add(float64(payloadSize))
+}
+
+func (m *metrics) rtpLoss(direction string, lost int64) {
+	if m == nil || lost <= 0 {
+		return
+	}
+
+	m.rtpLost.WithLabelValues(direction).Add(float64(lost))
+}
+
+func (m *metrics) jitter(direction string, jitter time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.rtpJitter.WithLabelValues(direction).Observe(jitter.Seconds())
+}
+
+func (m *metrics) unexpectedPayloadType() {
+	if m == nil {
+		return
+	}
+
+	m.unexpectedTypes.Inc()
+}
+
+func (m *metrics) rtcp(direction string, packets int) {
+	if m == nil {
+		return
+	}
+
+	m.rtcpPackets.WithLabelValues(direction).Add(float64(packets))
+}
+
+var (
+	mixerUnderrunsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "mixer", "underruns_total"),
+		"Times a mixer input ran dry and started buffering again.", nil, nil,
+	)
+	mixerOverflowFramesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "mixer", "overflow_frames_total"),
+		"Frames written to a full mixer input, discarding buffered audio.", nil, nil,
+	)
+	mixerOverflowSamplesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "mixer", "overflow_samples_total"),
+		"Samples discarded from full mixer inputs.", nil, nil,
+	)
+	mixerBlockedMixesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "mixer", "blocked_mixes_total"),
+		"Mixes dropped because the RTP leg did not keep up.", nil, nil,
+	)
+	mixerWriteErrorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "mixer", "write_errors_total"),
+		"Mixed frames that failed to be written to the RTP leg.", nil, nil,
+	)
+)
+
+// mixerCollector adds up the mixer.Stats of the sessions when scraped. The
+// counters of ended sessions are kept, so the totals never go down.
+type mixerCollector struct {
+	mx      sync.Mutex
+	live    map[*mixer.Stats]struct{}
+	retired MixerStats
+}
+
+func newMixerCollector() *mixerCollector {
+	return &mixerCollector{
+		live: make(map[*mixer.Stats]struct{}),
+	}
+}
+
+func (c *mixerCollector) add(stats *mixer.Stats) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.live[stats] = struct{}{}
+}
+
+func (c *mixerCollector) remove(stats *mixer.Stats) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if _, ok := c.live[stats]; !ok {
+		return
+	}
+
+	delete(c.live, stats)
+	c.retired.add(newMixerStats(stats))
+}
+
+func (c *mixerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- mixerUnderrunsDesc
+	ch <- mixerOverflowFramesDesc
+	ch <- mixerOverflowSamplesDesc
+	ch <- mixerBlockedMixesDesc
+	ch <- mixerWriteErrorsDesc
+}
+
+func (c *mixerCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mx.Lock()
+	total := c.retired
+	for stats := range c.live {
+		total.add(newMixerStats(stats))
+	}
+	c.mx.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(mixerUnderrunsDesc, prometheus.CounterValue, float64(total.Restarts))
+	ch <- prometheus.MustNewConstMetric(mixerOverflowFramesDesc, prometheus.CounterValue, float64(total.InputFramesDropped))
+	ch <- prometheus.MustNewConstMetric(mixerOverflowSamplesDesc, prometheus.CounterValue, float64(total.InputSamplesDropped))
+	ch <- prometheus.MustNewConstMetric(mixerBlockedMixesDesc, prometheus.CounterValue, float64(total.BlockedMixes))
+	ch <- prometheus.MustNewConstMetric(mixerWriteErrorsDesc, prometheus.CounterValue, float64(total.WriteErrors))
+}
+
+func (s *MixerStats) add(o MixerStats) {
+	s.Tracks += o.Tracks
+	s.TracksTotal += o.TracksTotal
+	s.Restarts += o.Restarts
+	s.TimingResets += o.TimingResets
+
+	s.Mixes += o.Mixes
+	s.TimedMixes += o.TimedMixes
+	s.JumpMixes += o.JumpMixes
+	s.ZeroMixes += o.ZeroMixes
+	s.NegativeMixes += o.NegativeMixes
+
+	s.InputSamples += o.InputSamples
+	s.InputFrames += o.InputFrames
+	s.InputSamplesDropped += o.InputSamplesDropped
+	s.InputFramesDropped += o.InputFramesDropped
+
+	s.MixedSamples += o.MixedSamples
+	s.MixedFrames += o.MixedFrames
+
+	s.OutputSamples += o.OutputSamples
+	s.OutputFrames += o.OutputFrames
+
+	s.WriteErrors += o.WriteErrors
+	s.BlockedMixes += o.BlockedMixes
+}
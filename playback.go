@@ -0,0 +1,432 @@
+package rtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/livekit/media-sdk"
+	"github.com/livekit/media-sdk/mixer"
+	"github.com/livekit/media-sdk/rtp"
+	"github.com/livekit/protocol/logger"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/pion/webrtc/v4"
+	webrtcmedia "github.com/pion/webrtc/v4/pkg/media"
+	opusv2 "gopkg.in/hraban/opus.v2"
+)
+
+// PlayTarget selects who hears a playback.
+type PlayTarget int
+
+const (
+	// PlayToCaller mixes the playback into the audio sent to the RTP peer.
+	PlayToCaller PlayTarget = 1 << iota
+	// PlayToRoom publishes the playback as a separate track in the room.
+	PlayToRoom
+
+	PlayToBoth = PlayToCaller | PlayToRoom
+)
+
+// PlayOption configures Play.
+type PlayOption func(*playConfig)
+
+type playConfig struct {
+	loop bool
+}
+
+// WithLoop plays the source over and over until the playback is stopped.
+// The source must be one of NewWAVSource, NewRawPCMSource or NewOggOpusSource.
+func WithLoop() PlayOption {
+	return func(c *playConfig) {
+		c.loop = true
+	}
+}
+
+// Playback is an AudioSource being played, see Manager.Play.
+type Playback struct {
+	id      string
+	session *session
+	log     logger.Logger
+	src     AudioSource
+	loop    bool
+	frame   []int16
+
+	mx       sync.Mutex
+	toCaller media.PCM16Writer
+	toRoom   media.PCM16Writer
+	track    *lksdk.LocalTrack
+	trackSID string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	// reported is closed once OnPlaybackFinished returned, or was dropped
+	// because the session ended first, see waitFinished. Both under mx.
+	reported  chan struct{}
+	reporting bool
+	dropped   bool
+
+	interrupted bool
+	err         error
+
+	writeErrors logLimit
+}
+
+// ID identifies the playback in Events.OnPlaybackFinished.
+func (p *Playback) ID() string {
+	return p.id
+}
+
+// Stop interrupts the playback. Done is closed once it stopped.
+func (p *Playback) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// Done is closed when the playback finished or was stopped.
+func (p *Playback) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err is the error that ended the playback early, valid once Done is closed.
+func (p *Playback) Err() error {
+	<-p.done
+
+	return p.err
+}
+
+// Play plays src to the RTP peer, to the room, or both. It returns once the
+// playback started; Events.OnPlaybackFinished and Playback.Done tell when it
+// ended. ctx bounds the start, publishing the track of PlayToRoom, not the
+// playback itself.
+func (r *Manager) Play(ctx context.Context, sID string, target PlayTarget, src AudioSource, opts ...PlayOption) (*Playback, error) {
+	session, ok := r.getSession(sID)
+
+	if !ok {
+		return nil, fmt.Errorf("session %s: %w", sID, ErrNotFound)
+	}
+
+	// RebindRTP moves the playbacks it finds to its mixer, so this one is
+	// attached and registered before a rebind may run
+	session.ops.Lock()
+	defer session.ops.Unlock()
+
+	if err := session.expect(opPlay, StateConnected, StateBound, StateActive); err != nil {
+		return nil, err
+	}
+
+	if target&PlayToBoth == 0 {
+		return nil, fmt.Errorf("session %s: invalid play target %d", sID, target)
+	}
+
+	conf := &playConfig{}
+	for _, opt := range opts {
+		opt(conf)
+	}
+
+	if _, ok := src.(rewinder); conf.loop && !ok {
+		return nil, fmt.Errorf("session %s: cannot loop %T", sID, src)
+	}
+
+	id := fmt.Sprintf("%s-playback-%d", sID, time.Now().UnixNano())
+
+	p := &Playback{
+		id:      id,
+		session: session,
+		log:     session.log.WithValues("playbackID", id),
+		src:     src,
+		loop:    conf.loop,
+		frame:   make([]int16, src.SampleRate()/rtp.DefFramesPerSec),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+
+		reported: make(chan struct{}),
+	}
+
+	mix, channels := session.getMixer()
+	if target&PlayToCaller != 0 && mix == nil {
+		return nil, fmt.Errorf("session %s: cannot play to the caller: %w", sID, ErrSessionNotBound)
+	}
+
+	if target&PlayToRoom != 0 {
+		if err := p.publish(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// registered first and checked again, as the session may have been
+	// disconnected while publishing: DisconnectFromRoom either stops this
+	// playback or has closed the session already
+	session.addPlayback(p)
+
+	if err := session.expect(opPlay, StateConnected, StateBound, StateActive); err != nil {
+		p.abort()
+
+		return nil, err
+	}
+
+	if target&PlayToCaller != 0 {
+		if err := p.attach(mix, channels); err != nil {
+			p.abort()
+
+			return nil, err
+		}
+	}
+
+	p.log.Infow("started playback", "target", target, "loop", conf.loop)
+
+	go p.run()
+
+	return p, nil
+}
+
+// attach mixes the playback into mix, replacing the mixer it was mixed into.
+func (p *Playback) attach(mix *mixer.Mixer, _ int) error {
+	input := mix.NewInput()
+	if input == nil {
+		return fmt.Errorf("playback %s: mixer already stopped", p.id)
+	}
+
+	toCaller := media.ResampleWriter(input, p.src.SampleRate())
+
+	p.mx.Lock()
+	prev := p.toCaller
+	p.toCaller = toCaller
+	p.mx.Unlock()
+
+	if prev != nil {
+		if err := prev.Close(); err != nil {
+			p.log.Warnw("failed to close mixer input", err)
+		}
+	}
+
+	return nil
+}
+
+// toCallerAttached tells if the caller hears the playback.
+func (p *Playback) toCallerAttached() bool {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	return p.toCaller != nil
+}
+
+// publish creates the track the room hears the playback on.
+func (p *Playback) publish(ctx context.Context) error {
+	track, err := lksdk.NewLocalTrack(webrtc.RTPCodecCapability{
+		MimeType:  webrtc.MimeTypeOpus,
+		ClockRate: publishSampleRate,
+	})
+	if err != nil {
+		return fmt.Errorf("playback %s: failed to create track: %w", p.id, err)
+	}
+
+	encoder, err := opusv2.NewEncoder(publishSampleRate, 1, opusv2.AppAudio)
+	if err != nil {
+		return fmt.Errorf("playback %s: failed to create Opus encoder: %w", p.id, err)
+	}
+
+	publication, err := publishTrack(ctx, p.session.room.LocalParticipant, track, &lksdk.TrackPublicationOptions{
+		Name:   p.id,
+		Stream: track.StreamID(),
+	})
+	if err != nil {
+		return fmt.Errorf("playback %s: %w: %w", p.id, ErrPublishFailed, err)
+	}
+
+	p.mx.Lock()
+	p.track, p.trackSID = track, publication.SID()
+	p.toRoom = media.ResampleWriter(&trackWriter{track: track, encoder: encoder}, p.src.SampleRate())
+	p.mx.Unlock()
+
+	return nil
+}
+
+// run feeds the source to the targets in real time until it ends or is stopped.
+func (p *Playback) run() {
+	defer p.finish()
+
+	ticker := time.NewTicker(rtp.DefFrameDur)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			p.interrupted = true
+
+			return
+		case <-ticker.C:
+		}
+
+		n, err := p.src.ReadPCM(p.frame)
+		if n > 0 {
+			p.write(p.frame[:n])
+		}
+
+		switch {
+		case errors.Is(err, io.EOF) && p.loop:
+			if err := p.src.(rewinder).Rewind(); err != nil {
+				p.err = fmt.Errorf("failed to rewind: %w", err)
+
+				return
+			}
+		case errors.Is(err, io.EOF):
+			return
+		case err != nil:
+			p.err = err
+
+			return
+		}
+	}
+}
+
+func (p *Playback) write(pcm media.PCM16Sample) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.toCaller != nil {
+		if err := p.toCaller.WriteSample(pcm); err != nil {
+			p.writeErrors.Debugw(p.log, "failed to write to the caller", "error", err)
+		}
+	}
+
+	if p.toRoom != nil {
+		if err := p.toRoom.WriteSample(pcm); err != nil {
+			p.writeErrors.Debugw(p.log, "failed to write to the room", "error", err)
+		}
+	}
+}
+
+func (p *Playback) finish() {
+	p.release()
+	p.session.removePlayback(p)
+
+	if p.err != nil {
+		p.log.Warnw("playback failed", p.err)
+	}
+
+	p.log.Infow("finished playback", "interrupted", p.interrupted)
+
+	close(p.done)
+
+	p.mx.Lock()
+	report := !p.dropped
+	p.reporting = report
+	p.mx.Unlock()
+
+	if report {
+		p.session.events.OnPlaybackFinished(p.session.id, p.id, p.interrupted)
+	}
+
+	close(p.reported)
+}
+
+// abort undoes a Play failing after the playback was registered.
+func (p *Playback) abort() {
+	p.release()
+	p.session.removePlayback(p)
+
+	close(p.done)
+	close(p.reported)
+}
+
+// waitFinished waits until the playback is done and reported to Events, or
+// until ctx is done. OnPlaybackFinished is not called from then on, so it
+// never follows OnSessionEnded, unless the call already started; it is
+// waited for then.
+func (p *Playback) waitFinished(ctx context.Context) {
+	select {
+	case <-p.reported:
+		return
+	case <-ctx.Done():
+	}
+
+	p.mx.Lock()
+	p.dropped = true
+	reporting := p.reporting
+	p.mx.Unlock()
+
+	if reporting {
+		<-p.reported
+	}
+}
+
+// release detaches the playback from the mixer and unpublishes its track.
+func (p *Playback) release() {
+	p.mx.Lock()
+	toCaller, toRoom, track, trackSID := p.toCaller, p.toRoom, p.track, p.trackSID
+	p.toCaller, p.toRoom, p.track = nil, nil, nil
+	p.mx.Unlock()
+
+	if toCaller != nil {
+		if err := toCaller.Close(); err != nil {
+			p.log.Warnw("failed to close mixer input", err)
+		}
+	}
+
+	if toRoom != nil {
+		if err := toRoom.Close(); err != nil {
+			p.log.Warnw("failed to flush track", err)
+		}
+	}
+
+	if track == nil {
+		return
+	}
+
+	if err := p.session.room.LocalParticipant.UnpublishTrack(trackSID); err != nil {
+		p.log.Warnw("failed to unpublish track", err, "trackSID", trackSID)
+	}
+
+	if err := track.Close(); err != nil {
+		p.log.Warnw("failed to close track", err)
+	}
+}
+
+// trackWriter encodes 48 kHz mono PCM into 20 ms Opus samples of a track.
+type trackWriter struct {
+	track   *lksdk.LocalTrack
+	encoder *opusv2.Encoder
+	buf     []int16
+}
+
+func (w *trackWriter) String() string {
+	return "playback track " + w.track.ID()
+}
+
+func (w *trackWriter) SampleRate() int {
+	return publishSampleRate
+}
+
+func (w *trackWriter) WriteSample(pcm media.PCM16Sample) error {
+	w.buf = append(w.buf, pcm...)
+
+	frame := publishSampleRate / rtp.DefFramesPerSec
+
+	for len(w.buf) >= frame {
+		data := make([]byte, inboundMTU)
+
+		n, err := w.encoder.Encode(w.buf[:frame], data)
+		if err != nil {
+			return fmt.Errorf("failed to encode: %w", err)
+		}
+
+		if err := w.track.WriteSample(webrtcmedia.Sample{Data: data[:n], Duration: rtp.DefFrameDur}, nil); err != nil {
+			return err
+		}
+
+		rest := copy(w.buf, w.buf[frame:])
+		w.buf = w.buf[:rest]
+	}
+
+	return nil
+}
+
+func (w *trackWriter) Close() error {
+	return nil
+}
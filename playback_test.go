@@ -0,0 +1,72 @@
+package rtp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/livekit/protocol/logger"
+)
+
+// playbackEvents records the playbacks reported finished.
+type playbackEvents struct {
+	NopEvents
+
+	finished []string
+}
+
+func (e *playbackEvents) OnPlaybackFinished(_, playbackID string, _ bool) {
+	e.finished = append(e.finished, playbackID)
+}
+
+func TestPlaybackWaitFinished(t *testing.T) {
+	newPlayback := func(events Events) *Playback {
+		s := &session{id: "s1", events: events, log: logger.GetLogger(), playbacks: make(map[*Playback]struct{})}
+
+		p := &Playback{
+			id:       "p1",
+			session:  s,
+			log:      s.log,
+			stop:     make(chan struct{}),
+			done:     make(chan struct{}),
+			reported: make(chan struct{}),
+		}
+
+		s.addPlayback(p)
+
+		return p
+	}
+
+	t.Run("reported", func(t *testing.T) {
+		events := &playbackEvents{}
+		p := newPlayback(events)
+
+		go func() {
+			<-p.stop
+			p.finish()
+		}()
+
+		p.Stop()
+		p.waitFinished(context.Background())
+
+		if len(events.finished) != 1 {
+			t.Errorf("reported %v, want the playback before waitFinished returns", events.finished)
+		}
+	})
+
+	t.Run("too late", func(t *testing.T) {
+		events := &playbackEvents{}
+		p := newPlayback(events)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		p.waitFinished(ctx)
+		p.finish()
+
+		if len(events.finished) != 0 {
+			t.Errorf("reported %v after the wait gave up", events.finished)
+		}
+
+		<-p.Done()
+	})
+}
@@ -0,0 +1,199 @@
+package rtp
+
+import (
+	"time"
+)
+
+const (
+	// plcHistory, plcPitchMin and plcPitchMax follow ITU-T G.711 Appendix I:
+	// 48.75 ms of history, pitch searched between 5 and 15 ms.
+	plcHistory  = 48750 * time.Microsecond
+	plcPitchMin = 5 * time.Millisecond
+	plcPitchMax = 15 * time.Millisecond
+
+	// plcCorrelation is the window matched against the history to find the pitch.
+	plcCorrelation = 20 * time.Millisecond
+
+	// plcAttenuationStart is when the synthetic signal starts fading out,
+	// by plcAttenuationStep per 10 ms, so it is silent after plcMute.
+	plcAttenuationStart = 10 * time.Millisecond
+	plcAttenuationStep  = 0.2
+	plcMute             = 60 * time.Millisecond
+
+	// plcMergeMax bounds the overlap with the first good frame after a loss.
+	plcMergeMax = 10 * time.Millisecond
+)
+
+// waveformPLC conceals lost frames of a PCM stream by repeating its last
+// pitch period, in the spirit of ITU-T G.711 Appendix I.
+type waveformPLC struct {
+	sampleRate int
+
+	history []int16
+
+	// state of the current erasure, lost is the number of samples concealed so far
+	lost   int
+	pitch  []float64
+	offset int
+}
+
+func newWaveformPLC(sampleRate int) *waveformPLC {
+	return &waveformPLC{
+		sampleRate: sampleRate,
+		history:    make([]int16, 0, samplesIn(plcHistory, sampleRate)),
+	}
+}
+
+func samplesIn(d time.Duration, sampleRate int) int {
+	return int(d * time.Duration(sampleRate) / time.Second)
+}
+
+// Good records a frame that was received. The first frame after a loss is
+// merged in place with the synthetic signal, so the transition is smooth.
+func (p *waveformPLC) Good(pcm []int16) {
+	if p.lost > 0 {
+		p.merge(pcm)
+		p.lost, p.pitch, p.offset = 0, nil, 0
+	}
+
+	p.remember(pcm)
+}
+
+// Conceal fills pcm with a synthetic continuation of the signal.
+func (p *waveformPLC) Conceal(pcm []int16) {
+	if p.lost == 0 {
+		p.pitch = p.pitchPeriod()
+	}
+
+	for i := range pcm {
+		pcm[i] = p.next()
+	}
+
+	p.remember(pcm)
+}
+
+// next returns the following synthetic sample, faded out as the erasure grows.
+func (p *waveformPLC) next() int16 {
+	if len(p.pitch) == 0 {
+		p.lost++
+
+		return 0
+	}
+
+	v := p.pitch[p.offset] * p.gain()
+
+	p.offset = (p.offset + 1) % len(p.pitch)
+	p.lost++
+
+	return clampPCM(v)
+}
+
+func (p *waveformPLC) gain() float64 {
+	start := samplesIn(plcAttenuationStart, p.sampleRate)
+	if p.lost < start {
+		return 1
+	}
+
+	if p.lost >= samplesIn(plcMute, p.sampleRate) {
+		return 0
+	}
+
+	step := samplesIn(10*time.Millisecond, p.sampleRate)
+
+	return max(0, 1-plcAttenuationStep*float64(p.lost-start)/float64(step))
+}
+
+// merge cross-fades from the synthetic signal into pcm. The overlap
+// grows with the length of the erasure, up to plcMergeMax.
+func (p *waveformPLC) merge(pcm []int16) {
+	if len(p.pitch) == 0 {
+		return
+	}
+
+	n := len(p.pitch)/4 + samplesIn(4*time.Millisecond, p.sampleRate)*(p.lost/max(1, samplesIn(10*time.Millisecond, p.sampleRate)))
+	n = min(n, samplesIn(plcMergeMax, p.sampleRate), len(pcm))
+
+	for i := 0; i < n; i++ {
+		w := float64(i+1) / float64(n+1)
+		synthetic := float64(p.next())
+		pcm[i] = clampPCM(w*float64(pcm[i]) + (1-w)*synthetic)
+	}
+}
+
+func (p *waveformPLC) remember(pcm []int16) {
+	size := cap(p.history)
+	if len(pcm) >= size {
+		p.history = append(p.history[:0], pcm[len(pcm)-size:]...)
+
+		return
+	}
+
+	if drop := len(p.history) + len(pcm) - size; drop > 0 {
+		p.history = append(p.history[:0], p.history[drop:]...)
+	}
+
+	p.history = append(p.history, pcm...)
+}
+
+// pitchPeriod finds the pitch of the history by autocorrelation and returns
+// its last period, with the wrap-around smoothed over a quarter period.
+func (p *waveformPLC) pitchPeriod() []float64 {
+	h := p.history
+	window := samplesIn(plcCorrelation, p.sampleRate)
+	minLag, maxLag := samplesIn(plcPitchMin, p.sampleRate), samplesIn(plcPitchMax, p.sampleRate)
+
+	if len(h) < window+maxLag {
+		return nil
+	}
+
+	end := h[len(h)-window:]
+
+	bestLag, bestScore := 0, 0.0
+	for lag := minLag; lag <= maxLag; lag++ {
+		past := h[len(h)-window-lag : len(h)-lag]
+
+		var corr, energy float64
+		for i := range end {
+			corr += float64(end[i]) * float64(past[i])
+			energy += float64(past[i]) * float64(past[i])
+		}
+
+		if energy == 0 || corr <= 0 {
+			continue
+		}
+
+		if score := corr * corr / energy; score > bestScore {
+			bestLag, bestScore = lag, score
+		}
+	}
+
+	if bestLag == 0 {
+		return nil
+	}
+
+	period := make([]float64, bestLag)
+	for i := range period {
+		period[i] = float64(h[len(h)-bestLag+i])
+	}
+
+	// the samples preceding the period flow into its start, fade the end into them
+	q := bestLag / 4
+	for i := 0; i < q; i++ {
+		w := float64(i+1) / float64(q+1)
+		before := float64(h[len(h)-bestLag-q+i])
+		period[bestLag-q+i] = (1-w)*period[bestLag-q+i] + w*before
+	}
+
+	return period
+}
+
+func clampPCM(v float64) int16 {
+	switch {
+	case v > 32767:
+		return 32767
+	case v < -32768:
+		return -32768
+	default:
+		return int16(v)
+	}
+}
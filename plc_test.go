@@ -0,0 +1,160 @@
+package rtp
+
+import (
+	"math"
+	"testing"
+)
+
+// plcWithTone returns a waveformPLC at 8 kHz whose history is a 125 Hz
+// tone, a pitch period of 64 samples.
+func plcWithTone(t *testing.T) *waveformPLC {
+	t.Helper()
+
+	p := newWaveformPLC(8000)
+
+	tone := make([]int16, 480)
+	for i := range tone {
+		tone[i] = int16(10000 * math.Sin(2*math.Pi*125*float64(i)/8000))
+	}
+
+	for off := 0; off < len(tone); off += 80 {
+		p.Good(tone[off : off+80])
+	}
+
+	return p
+}
+
+func peak(pcm []int16) int {
+	m := 0
+	for _, v := range pcm {
+		m = max(m, abs(int(v)))
+	}
+
+	return m
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
+
+func TestWaveformPLCGain(t *testing.T) {
+	cases := []struct {
+		lost int
+		want float64
+	}{
+		{lost: 0, want: 1},
+		{lost: 79, want: 1},
+		{lost: 80, want: 1},
+		{lost: 120, want: 0.9},
+		{lost: 160, want: 0.8},
+		{lost: 400, want: 0.2},
+		{lost: 480, want: 0},
+		{lost: 1000, want: 0},
+	}
+
+	for _, tc := range cases {
+		p := newWaveformPLC(8000)
+		p.lost = tc.lost
+
+		if got := p.gain(); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("gain after %d lost samples = %v, want %v", tc.lost, got, tc.want)
+		}
+	}
+}
+
+func TestWaveformPLCAttenuation(t *testing.T) {
+	p := plcWithTone(t)
+
+	if got := len(p.pitchPeriod()); got != 64 {
+		t.Fatalf("pitch period = %d samples, want 64", got)
+	}
+
+	// peaks of the 10 ms frames concealed one after another
+	cases := []struct {
+		frame    int
+		min, max int
+	}{
+		{frame: 0, min: 9000, max: 10000},
+		{frame: 1, min: 7000, max: 10000},
+		{frame: 2, min: 5000, max: 8100},
+		{frame: 4, min: 1000, max: 4100},
+		{frame: 6, min: 0, max: 0},
+		{frame: 9, min: 0, max: 0},
+	}
+
+	frames := make([][]int16, 10)
+	for i := range frames {
+		frames[i] = make([]int16, 80)
+		p.Conceal(frames[i])
+	}
+
+	for _, tc := range cases {
+		if got := peak(frames[tc.frame]); got < tc.min || got > tc.max {
+			t.Errorf("peak of concealed frame %d = %d, want %d..%d", tc.frame, got, tc.min, tc.max)
+		}
+	}
+}
+
+func TestWaveformPLCWithoutHistory(t *testing.T) {
+	p := newWaveformPLC(8000)
+
+	pcm := []int16{1, 2, 3, 4}
+	p.Conceal(pcm)
+
+	if got := peak(pcm); got != 0 {
+		t.Errorf("concealed without history, peak = %d, want silence", got)
+	}
+}
+
+func TestWaveformPLCMerge(t *testing.T) {
+	cases := []struct {
+		name string
+		lost int
+		// overlap is len(pitch)/4 + 4 ms per 10 ms lost, up to plcMergeMax
+		overlap int
+	}{
+		{name: "no loss", lost: 0, overlap: 0},
+		{name: "one frame", lost: 1, overlap: 16 + 32},
+		{name: "two frames", lost: 2, overlap: 80},
+		{name: "long loss", lost: 5, overlap: 80},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := plcWithTone(t)
+
+			for range tc.lost {
+				p.Conceal(make([]int16, 80))
+			}
+
+			const level = 5000
+
+			good := make([]int16, 160)
+			for i := range good {
+				good[i] = level
+			}
+
+			p.Good(good)
+
+			for i, v := range good[tc.overlap:] {
+				if v != level {
+					t.Fatalf("sample %d = %d, want %d past the overlap of %d", tc.overlap+i, v, level, tc.overlap)
+				}
+			}
+
+			for i, v := range good[:tc.overlap] {
+				if v == level {
+					t.Errorf("sample %d = %d, not merged with the synthetic signal", i, v)
+				}
+			}
+
+			if p.lost != 0 || p.pitch != nil {
+				t.Errorf("erasure not reset after a good frame: lost %d, pitch %d", p.lost, len(p.pitch))
+			}
+		})
+	}
+}
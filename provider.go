@@ -2,110 +2,482 @@ package rtp
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sync/atomic"
 	"time"
 
-	"github.com/livekit/media-sdk/g711"
+	"github.com/livekit/media-sdk"
+	"github.com/livekit/media-sdk/dtmf"
 	"github.com/livekit/media-sdk/opus"
 	"github.com/livekit/media-sdk/rtp"
-	"github.com/pion/webrtc/v4/pkg/media"
+	webrtcmedia "github.com/pion/webrtc/v4/pkg/media"
 	opusv2 "gopkg.in/hraban/opus.v2"
 )
 
 const (
 	inboundMTU = 1500
 
-	PayloadTypePCMU = 0
-	PayloadTypePCMA = 8
+	// maxInboundPacket is the largest UDP datagram. Uncompressed L16 frames
+	// exceed the MTU and arrive fragmented.
+	maxInboundPacket = 65535
+
+	PayloadTypePCMU      = 0
+	PayloadTypePCMA      = 8
+	PayloadTypeG722      = 9
+	PayloadTypeL16Stereo = 10
+	PayloadTypeL16Mono   = 11
+	PayloadTypeCN        = 13
 
 	PayloadTypeDynamicStart = 96
 	PayloadTypeDynamicEnd   = 127
+
+	// maxConcealedFrames bounds how many lost frames are replaced after a gap.
+	maxConcealedFrames = 5
+
+	// maxDropout is the RFC 3550 sequence jump treated as a restart rather than a loss.
+	maxDropout = 3000
+
+	// maxOpusFrame is the longest Opus frame, 120 ms at 48 kHz.
+	maxOpusFrame = 5760
+
+	// g722SampleRate is the audio rate of G.722, twice its RTP clock rate.
+	g722SampleRate = 16000
+
+	// publishSampleRate is the rate of the Opus track published to LiveKit.
+	publishSampleRate = 48000
+
+	// mixChannels is the channel count of the audio bridged between the legs,
+	// the mixer is mono. Codecs with more channels convert in their CodecFactory.
+	mixChannels = 1
+
+	// defaultMixerSampleRate is the rate LiveKit tracks are mixed at, see WithMixerSampleRate.
+	defaultMixerSampleRate = 48000
 )
 
+// inboundCodec is one of the codecs the peer may send, by payload type.
+type inboundCodec struct {
+	codec Codec
+
+	// decoder writes PCM at sampleRate into the decoded buffer of the
+	// provider. It is nil for Opus, which is forwarded as is.
+	decoder    PayloadWriter
+	sampleRate int
+	resampler  media.PCM16Writer
+	plc        *waveformPLC
+
+	// opus follows Opus payloads, so lost frames can be recovered from FEC
+	opus *opusv2.Decoder
+
+	frameSamples int
+}
+
+// rtpSampleProvider publishes the RTP received from the peer as 48 kHz Opus.
+// Audio is decoded with the codec registered for its payload type, resampled
+// to publishSampleRate and encoded again. Opus is forwarded as is. Comfort
+// noise fills the silences the peer suppresses.
 type rtpSampleProvider struct {
-	stream      rtp.ReadStream
-	header      *rtp.Header
-	payload     []byte
-	payloadType uint8
-	clockRate   int
-	encoder     *opusv2.Encoder
+	stream   *streamRTP
+	header   *rtp.Header
+	payload  []byte
+	channels int
+	encoder  *opusv2.Encoder
+
+	codecs    map[uint8]*inboundCodec
+	decoded   media.PCM16Sample
+	resampled media.PCM16Sample
+	pcm       []int16
+	stats     *streamStats
+
+	started bool
+	ssrc    uint32
+	seq     uint16
+	lost    int
+	pending []webrtcmedia.Sample
+
+	dtmfType     uint8
+	dtmfReceiver *dtmfReceiver
+
+	cnType uint8
+	noise  comfortNoise
+
+	recording *atomic.Pointer[Recording]
+
+	// limits of the messages logged per packet
+	unexpectedType, mediaErrors logLimit
 }
 
-func newRTPSampleProvider(stream rtp.ReadStream, payloadType uint8, clockRate, channels int) (*rtpSampleProvider, error) {
-	encoder, err := opusv2.NewEncoder(clockRate, channels, opusv2.AppVoIP)
+func newRTPSampleProvider(stream *streamRTP, codecs []Codec, channels int, dtmfType, cnType uint8, onDTMF dtmf.Handler, stats *streamStats) (*rtpSampleProvider, error) {
+	encoder, err := opusv2.NewEncoder(publishSampleRate, channels, opusv2.AppVoIP)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Opus encoder in newRTPSampleProvider: %w", err)
 	}
 
-	return &rtpSampleProvider{
-		stream:      stream,
-		header:      &rtp.Header{},
-		payload:     make([]byte, inboundMTU),
-		payloadType: payloadType,
-		clockRate:   clockRate,
-		encoder:     encoder,
+	s := &rtpSampleProvider{
+		stream:   stream,
+		header:   &rtp.Header{},
+		payload:  make([]byte, maxInboundPacket),
+		channels: channels,
+		encoder:  encoder,
+		codecs:   make(map[uint8]*inboundCodec),
+		pcm:      make([]int16, maxOpusFrame*channels),
+		stats:    stats,
+
+		dtmfType:     dtmfType,
+		dtmfReceiver: newDTMFReceiver(onDTMF, stream.log),
+
+		cnType: cnType,
+	}
+
+	for _, codec := range codecs {
+		if codec.auxiliary() {
+			continue
+		}
+
+		c, err := s.newInboundCodec(codec)
+		if err != nil {
+			return nil, err
+		}
+
+		if c != nil {
+			s.codecs[codec.PayloadType] = c
+		}
+	}
+
+	if len(s.codecs) == 0 {
+		return nil, fmt.Errorf("%w: none of %v", ErrUnsupportedCodec, codecs)
+	}
+
+	return s, nil
+}
+
+func (s *rtpSampleProvider) newInboundCodec(codec Codec) (*inboundCodec, error) {
+	if codec.is(CodecOpus) {
+		decoder, err := opusv2.NewDecoder(publishSampleRate, s.channels)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Opus decoder in newRTPSampleProvider: %w", err)
+		}
+
+		return &inboundCodec{
+			codec: codec,
+			opus:  decoder,
+		}, nil
+	}
+
+	factory, ok := lookupCodec(codec.Name)
+	if !ok {
+		s.stream.log.Infow("ignoring unsupported codec", "codec", codec)
+
+		return nil, nil
+	}
+
+	sampleRate := factory.sampleRate(codec)
+
+	decoder, err := factory.NewDecoder(codec, media.NewPCM16BufferWriter(&s.decoded, sampleRate))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s decoder in newRTPSampleProvider: %w", codec.Name, err)
+	}
+
+	return &inboundCodec{
+		codec:      codec,
+		decoder:    decoder,
+		sampleRate: sampleRate,
+		resampler:  media.ResampleWriter(media.NewPCM16BufferWriter(&s.resampled, publishSampleRate), sampleRate),
+		plc:        newWaveformPLC(sampleRate),
 	}, nil
 }
 
 func (r *rtpSampleProvider) Close() error {
+	for _, c := range r.codecs {
+		if c.resampler == nil {
+			continue
+		}
+
+		if err := c.resampler.Close(); err != nil {
+			r.stream.log.Warnw("failed to close resampler", err, "codec", c.codec.Name)
+		}
+	}
+
 	return nil
 }
 
-func (s *rtpSampleProvider) NextSample(context.Context) (media.Sample, error) {
-	sample := media.Sample{}
+// NextSample returns the next Opus sample to publish. It fails with io.EOF
+// once the stream is closed, and only then: a frame that cannot be decoded
+// or encoded is logged and dropped, so the track keeps reading.
+func (s *rtpSampleProvider) NextSample(context.Context) (webrtcmedia.Sample, error) {
+	// a packet may yield no sample while the resampler fills up, or several after a loss
+	for len(s.pending) == 0 {
+		if err := s.next(); err != nil {
+			return webrtcmedia.Sample{}, err
+		}
+	}
+
+	sample := s.pending[0]
+	s.pending = s.pending[1:]
+
+	return sample, nil
+}
+
+// next reads the following audio packet and queues what it yields. While
+// the peer sends comfort noise, it queues a noise frame whenever no packet
+// arrives within a frame.
+func (s *rtpSampleProvider) next() error {
+	for {
+		nSamples, err := s.read()
+		if errors.Is(err, errNoPacket) {
+			s.publishNoise()
+
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// telephone-events carry no audio, keep reading until the next audio packet
+		if s.dtmfType != 0 && s.header.PayloadType == s.dtmfType {
+			s.dtmfReceiver.HandleRTP(s.header, s.payload[:nSamples])
+
+			continue
+		}
+
+		if s.header.PayloadType == s.cnType {
+			s.noise.Update(s.payload[:nSamples])
+			s.publishNoise()
+
+			return nil
+		}
+
+		codec, ok := s.codecs[s.header.PayloadType]
+		if !ok {
+			s.stats.OnUnexpectedPayloadType()
+			s.unexpectedType.Debugw(s.stream.log, "unexpected payload type, dropping packet",
+				"payloadType", s.header.PayloadType,
+				"remoteSSRC", s.header.SSRC,
+				"remoteAddr", s.stream.remoteAddr(),
+			)
+
+			return nil
+		}
+
+		s.noise.Stop()
+		s.conceal(codec, nSamples)
+		s.decode(codec, nSamples)
+
+		return nil
+	}
+}
+
+// read reads the next packet and counts the packets missing before it. During
+// comfort noise it waits one frame at most and returns errNoPacket then.
+func (s *rtpSampleProvider) read() (int, error) {
+	var (
+		nSamples int
+		err      error
+	)
+
+	if s.noise.active {
+		nSamples, err = s.stream.ReadRTPTimeout(s.header, s.payload, rtp.DefFrameDur)
+	} else {
+		nSamples, err = s.stream.ReadRTP(s.header, s.payload)
+	}
+
+	if errors.Is(err, errNoPacket) {
+		return 0, err
+	}
 
-	nSamples, err := s.stream.ReadRTP(s.header, s.payload)
+	// the stream only fails once closed
 	if err != nil {
-		return sample, fmt.Errorf("failed to read from RTP socket: %w", err)
+		return 0, io.EOF
 	}
 
-	if s.header.PayloadType != s.payloadType {
-		fmt.Printf("unexpected payload type: got %d, want %d\n", s.header.PayloadType, s.payloadType)
+	if s.started && s.ssrc == s.header.SSRC && seqNewer(s.header.SequenceNumber, s.seq) {
+		if gap := int(s.header.SequenceNumber - s.seq - 1); gap < maxDropout {
+			s.lost += gap
+		}
 	}
 
-	sample.Duration = time.Duration(nSamples) * time.Second / time.Duration(s.clockRate)
+	s.started, s.ssrc, s.seq = true, s.header.SSRC, s.header.SequenceNumber
 
-	switch s.header.PayloadType {
-	case PayloadTypePCMA:
-		alawSample := make(g711.ALawSample, nSamples)
-		copy(alawSample, s.payload)
-		pcm := alawSample.Decode()
+	return nSamples, nil
+}
 
-		numSamplesEncoded, err := s.encoder.Encode(pcm, s.payload)
-		if err != nil {
-			return sample, fmt.Errorf("failed to encode PCMA(samples=%d) to Opus: %w", nSamples, err)
+// conceal replaces the frames lost before the current packet: decoded codecs
+// with waveform repetition, Opus with the FEC carried in the current packet
+// or the decoder's own concealment.
+func (s *rtpSampleProvider) conceal(c *inboundCodec, nSamples int) {
+	lost := s.lost
+	s.lost = 0
+
+	if lost == 0 {
+		return
+	}
+
+	var concealed, recovered int
+	defer func() {
+		s.stats.OnLostFrames(uint64(lost), uint64(concealed), uint64(recovered))
+	}()
+
+	if c.frameSamples == 0 {
+		// nothing decoded yet to conceal from
+		return
+	}
+
+	n := min(lost, maxConcealedFrames)
+
+	for i := range n {
+		if c.opus == nil {
+			// decoded audio is mono
+			frame := s.pcm[:c.frameSamples]
+			c.plc.Conceal(frame)
+			concealed++
+
+			if err := s.publishPCM(c, frame); err != nil {
+				s.mediaErrors.Debugw(s.stream.log, "failed to encode concealed frame", "error", err)
+
+				return
+			}
+
+			continue
 		}
 
-		sample.Data = s.payload[:numSamplesEncoded]
+		pcm := s.pcm[:c.frameSamples*s.channels]
 
-		return sample, nil
+		// the current packet carries the previous frame as FEC
+		if i == n-1 && c.opus.DecodeFEC(s.payload[:nSamples], pcm) == nil {
+			recovered++
+		} else if err := c.opus.DecodePLC(pcm); err != nil {
+			s.mediaErrors.Debugw(s.stream.log, "failed to conceal lost Opus frame", "error", err)
 
-	case PayloadTypePCMU:
-		ulawSample := make(g711.ULawSample, nSamples)
-		copy(ulawSample, s.payload)
-		pcm := ulawSample.Decode()
+			return
+		} else {
+			concealed++
+		}
 
-		numSamplesEncoded, err := s.encoder.Encode(pcm, s.payload)
+		// Opus is decoded at publishSampleRate already
+		sample, err := s.encode(pcm, c.frameSamples)
 		if err != nil {
-			return sample, fmt.Errorf("failed to encode PCMA(samples=%d) to Opus: %w", nSamples, err)
+			s.mediaErrors.Debugw(s.stream.log, "failed to encode concealed frame", "error", err)
+
+			return
 		}
 
-		sample.Data = s.payload[:numSamplesEncoded]
+		s.pending = append(s.pending, sample)
+	}
+}
+
+// publishNoise queues a frame of comfort noise.
+func (s *rtpSampleProvider) publishNoise() {
+	frame := s.pcm[:publishSampleRate*s.channels/rtp.DefFramesPerSec]
+	s.noise.Generate(frame)
+
+	sample, err := s.encode(frame, len(frame)/s.channels)
+	if err != nil {
+		s.mediaErrors.Debugw(s.stream.log, "failed to encode comfort noise", "error", err)
+
+		return
+	}
+
+	s.pending = append(s.pending, sample)
+}
+
+// publishPCM resamples pcm to publishSampleRate and queues it as Opus frames.
+func (s *rtpSampleProvider) publishPCM(c *inboundCodec, pcm media.PCM16Sample) error {
+	if err := c.resampler.WriteSample(pcm); err != nil {
+		return fmt.Errorf("failed to resample %d Hz to %d Hz: %w", c.sampleRate, publishSampleRate, err)
+	}
 
-		return sample, nil
+	frame := publishSampleRate * s.channels / rtp.DefFramesPerSec
 
-	default:
-		if !(PayloadTypeDynamicStart <= s.header.PayloadType && s.header.PayloadType <= PayloadTypeDynamicEnd) {
-			return sample, fmt.Errorf("failed to encode PayloadType=%v(samples=%d) to Opus", s.header.PayloadType, nSamples)
+	for len(s.resampled) >= frame {
+		sample, err := s.encode(s.resampled[:frame], frame/s.channels)
+		if err != nil {
+			return err
 		}
 
-		opusSample := make(opus.Sample, nSamples)
-		copy(opusSample, s.payload)
+		s.pending = append(s.pending, sample)
 
-		sample.Data = opusSample
+		n := copy(s.resampled, s.resampled[frame:])
+		s.resampled = s.resampled[:n]
 	}
 
-	return sample, nil
+	return nil
+}
+
+func (s *rtpSampleProvider) encode(pcm []int16, frameSamples int) (webrtcmedia.Sample, error) {
+	s.record(pcm)
+
+	data := make([]byte, inboundMTU)
+
+	n, err := s.encoder.Encode(pcm, data)
+	if err != nil {
+		return webrtcmedia.Sample{}, err
+	}
+
+	return webrtcmedia.Sample{
+		Data:     data[:n],
+		Duration: time.Duration(frameSamples) * time.Second / publishSampleRate,
+	}, nil
+}
+
+// decode queues the audio of the current packet, dropping it if it fails to
+// decode or encode.
+func (s *rtpSampleProvider) decode(c *inboundCodec, nSamples int) {
+	if c.opus != nil {
+		s.forwardOpus(c, nSamples)
+
+		return
+	}
+
+	s.decoded = s.decoded[:0]
+
+	if err := c.decoder.WriteSample(s.payload[:nSamples]); err != nil {
+		s.mediaErrors.Debugw(s.stream.log, "failed to decode frame", "error", err, "codec", c.codec.Name, "size", nSamples)
+
+		return
+	}
+
+	c.plc.Good(s.decoded)
+	c.frameSamples = len(s.decoded)
+
+	if err := s.publishPCM(c, s.decoded); err != nil {
+		s.mediaErrors.Debugw(s.stream.log, "failed to encode frame to Opus", "error", err, "codec", c.codec.Name, "size", nSamples)
+	}
+}
+
+func (s *rtpSampleProvider) forwardOpus(c *inboundCodec, nSamples int) {
+	opusSample := make(opus.Sample, nSamples)
+	copy(opusSample, s.payload)
+
+	sample := webrtcmedia.Sample{
+		Data:     opusSample,
+		Duration: rtp.DefFrameDur,
+	}
+
+	// keep the decoder in step with the stream, for FEC after a loss
+	frameSamples, err := c.opus.Decode(opusSample, s.pcm)
+	if err != nil {
+		s.mediaErrors.Debugw(s.stream.log, "failed to decode Opus frame", "error", err, "remoteSSRC", s.header.SSRC)
+	} else {
+		c.frameSamples = frameSamples
+		sample.Duration = time.Duration(frameSamples) * time.Second / publishSampleRate
+
+		s.record(s.pcm[:frameSamples*s.channels])
+	}
+
+	s.pending = append(s.pending, sample)
+}
+
+// record hands what is published to the recording of the session, if any.
+func (s *rtpSampleProvider) record(pcm []int16) {
+	if s.recording == nil {
+		return
+	}
+
+	if rec := s.recording.Load(); rec != nil {
+		rec.writeCaller(pcm)
+	}
 }
 
 func (r *rtpSampleProvider) OnBind() error {
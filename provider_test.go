@@ -0,0 +1,138 @@
+package rtp
+
+import (
+	"context"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/livekit/media-sdk"
+	"github.com/livekit/media-sdk/rtp"
+	"github.com/livekit/protocol/logger"
+	opusv2 "gopkg.in/hraban/opus.v2"
+)
+
+// goertzel returns the power of freq in pcm sampled at sampleRate.
+func goertzel(pcm []int16, sampleRate int, freq float64) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*freq/float64(sampleRate))
+
+	var s1, s2 float64
+	for _, v := range pcm {
+		s0 := float64(v) + coeff*s1 - s2
+		s2, s1 = s1, s0
+	}
+
+	return s1*s1 + s2*s2 - coeff*s1*s2
+}
+
+func sineTone(sampleRate int, freq float64, n int) media.PCM16Sample {
+	pcm := make(media.PCM16Sample, n)
+	for i := range pcm {
+		pcm[i] = int16(8000 * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate)))
+	}
+
+	return pcm
+}
+
+// TestPublishPCMKeepsToneFrequency runs a tone from the RTP leg through the
+// resampler to 48 kHz and the Opus encoder of the provider, decodes it and
+// resamples it back, and checks the tone is still the dominant frequency.
+func TestPublishPCMKeepsToneFrequency(t *testing.T) {
+	cases := []struct {
+		name       string
+		sampleRate int
+		freq       float64
+	}{
+		{name: "8k 440Hz", sampleRate: 8000, freq: 440},
+		{name: "8k 1kHz", sampleRate: 8000, freq: 1000},
+		{name: "8k 3kHz", sampleRate: 8000, freq: 3000},
+		{name: "16k 1kHz", sampleRate: 16000, freq: 1000},
+		{name: "16k 6kHz", sampleRate: 16000, freq: 6000},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			codec := Codec{Name: CodecL16, PayloadType: 96, ClockRate: tc.sampleRate}
+			stream := &streamRTP{log: logger.GetLogger()}
+
+			s, err := newRTPSampleProvider(stream, []Codec{codec}, mixChannels, 0, 0, nil, newStreamStats(1, "test", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			// one second of tone, in frames as they come from the peer
+			tone := sineTone(tc.sampleRate, tc.freq, tc.sampleRate)
+			frame := tc.sampleRate / 50
+
+			for off := 0; off < len(tone); off += frame {
+				if err := s.publishPCM(s.codecs[codec.PayloadType], tone[off:off+frame]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if len(s.pending) == 0 {
+				t.Fatal("no Opus frames published")
+			}
+
+			decoder, err := opusv2.NewDecoder(publishSampleRate, mixChannels)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out media.PCM16Sample
+			back := media.ResampleWriter(media.NewPCM16BufferWriter(&out, tc.sampleRate), publishSampleRate)
+
+			pcm := make([]int16, maxOpusFrame)
+			for _, sample := range s.pending {
+				n, err := decoder.Decode(sample.Data, pcm)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := back.WriteSample(pcm[:n]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := back.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// skip the start, while the codec and the resamplers settle
+			out = out[len(out)/4:]
+
+			want := goertzel(out, tc.sampleRate, tc.freq)
+			for _, other := range []float64{tc.freq / 2, tc.freq * 0.8, tc.freq * 1.25, tc.freq * 2} {
+				if other >= float64(tc.sampleRate)/2 {
+					continue
+				}
+
+				if got := goertzel(out, tc.sampleRate, other); got*10 > want {
+					t.Errorf("power at %v Hz is %.3g, not well below %.3g at %v Hz", other, got, want, tc.freq)
+				}
+			}
+		})
+	}
+}
+
+func TestNextSampleEOF(t *testing.T) {
+	stream := &streamRTP{log: logger.GetLogger(), rtpBuff: make(chan rtp.Packet, 1)}
+
+	stream.rtpBuff <- rtp.Packet{Header: rtp.Header{PayloadType: 99}, Payload: []byte{1}}
+	close(stream.rtpBuff)
+
+	s := &rtpSampleProvider{
+		stream:   stream,
+		header:   &rtp.Header{},
+		payload:  make([]byte, maxInboundPacket),
+		channels: 1,
+		codecs:   make(map[uint8]*inboundCodec),
+		stats:    newStreamStats(1, "test", nil),
+	}
+
+	// the packet is dropped, and the SDK stops reading the track on io.EOF only
+	if _, err := s.NextSample(context.Background()); err != io.EOF {
+		t.Errorf("closed stream: %v, want io.EOF", err)
+	}
+}
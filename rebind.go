@@ -0,0 +1,139 @@
+package rtp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/livekit/protocol/logger"
+)
+
+// RebindRTP moves an already bound session to a new RTP endpoint, codec or
+// ptime (e.g. after a re-INVITE). The room, the published track and the
+// subscribed tracks stay in place, so nobody in the room sees a leave/join,
+// and the RTP peer keeps seeing the same SSRC with continuous sequence
+// numbers and timestamps.
+//
+// connRTP and connRTCP may be the sockets of the current binding; sockets
+// that are not reused are closed. It fails with ctx.Err() when ctx is done
+// before the new binding replaces the current one, leaving that untouched.
+// Once replaced, the rebind completes regardless of ctx.
+func (r *Manager) RebindRTP(
+	ctx context.Context,
+	connRTP, connRTCP net.Conn,
+	sID, identity string,
+	codecs []Codec, pTime int,
+	rAddrRTP, rAddrRTCP *net.UDPAddr,
+	opts ...BindOption,
+) (err error) {
+	session, ok := r.getSession(sID)
+	if !ok {
+		return fmt.Errorf("session %s: %w", sID, ErrNotFound)
+	}
+
+	session.ops.Lock()
+	defer session.ops.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := session.expect(opRebind, StateBound, StateActive); err != nil {
+		return err
+	}
+
+	log := session.log.WithValues("remoteAddr", rAddrRTP)
+	log.Infow("rebinding RTP", "codecs", codecs, "pTime", pTime)
+
+	startedAt := time.Now()
+
+	defer func() {
+		r.metrics.observe(opRebind, startedAt, err)
+		log.Infow("finished rebinding RTP", "duration", time.Since(startedAt))
+	}()
+
+	session.mx.Lock()
+	prev := &binding{
+		streamRTP:   session.streamRTP,
+		mediaWriter: session.mediaWriter,
+		rtpProvider: session.rtpProvider,
+		mixer:       session.mixer,
+	}
+	track := session.track
+	session.mx.Unlock()
+
+	next, err := newBinding(ctx, session, prev, connRTP, connRTCP, codecs, pTime, rAddrRTP, rAddrRTCP, newBindConfig(opts))
+	if err != nil {
+		return fmt.Errorf("RebindRTP %s: failed to bind (identity: %s): %w", sID, identity, err)
+	}
+
+	prev.releaseConns(next, log)
+
+	// the session was closed while binding, prev is stopped already
+	if err := session.SetParams(
+		opRebind,
+		next.channels,
+		next.mixer,
+		track,
+		next.rtpProvider,
+		next.streamRTP,
+		connRTCP,
+		next.mediaWriter,
+		next.dtmfStream,
+	); err != nil {
+		next.mixer.Stop()
+		next.streamRTP.Close()
+
+		return err
+	}
+
+	for _, input := range session.getInputs() {
+		if err := input.attach(next.mixer, next.channels); err != nil {
+			log.Warnw("failed to reattach track", err, "trackID", input.id, "participant", input.identity)
+		}
+	}
+
+	for _, p := range session.getPlaybacks() {
+		if !p.toCallerAttached() {
+			continue
+		}
+
+		if err := p.attach(next.mixer, next.channels); err != nil {
+			log.Warnw("failed to reattach playback", err, "playbackID", p.id)
+		}
+	}
+
+	session.mx.Lock()
+	defer session.mx.Unlock()
+
+	if !session.writing {
+		return nil
+	}
+
+	// the previous provider has already hit EOF, StartWrite replaces its writer
+	if err := track.StartWrite(next.rtpProvider, nil); err != nil {
+		return fmt.Errorf("RebindRTP %s: start write to track failed (identity: %s): %w", sID, identity, err)
+	}
+
+	return nil
+}
+
+// releaseConns closes the sockets of b that next does not reuse.
+func (b *binding) releaseConns(next *binding, log logger.Logger) {
+	if b.streamRTP.connRTP != next.streamRTP.connRTP && b.streamRTP.connRTP != next.streamRTP.connRTCP {
+		if err := b.streamRTP.connRTP.Close(); err != nil {
+			log.Warnw("failed to close previous RTP conn", err)
+		}
+	}
+
+	if b.streamRTP.rtcpMux {
+		return
+	}
+
+	if b.streamRTP.connRTCP != next.streamRTP.connRTCP && b.streamRTP.connRTCP != next.streamRTP.connRTP {
+		if err := b.streamRTP.connRTCP.Close(); err != nil {
+			log.Warnw("failed to close previous RTCP conn", err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package rtp
+
+import (
+	"testing"
+
+	"github.com/livekit/media-sdk"
+	"github.com/livekit/media-sdk/rtp"
+)
+
+// packetBuffer keeps the RTP packets written to it.
+type packetBuffer struct {
+	packets []rtp.Packet
+}
+
+func (b *packetBuffer) String() string {
+	return "packetBuffer"
+}
+
+func (b *packetBuffer) WriteRTP(h *rtp.Header, payload []byte) (int, error) {
+	b.packets = append(b.packets, rtp.Packet{Header: *h, Payload: append([]byte(nil), payload...)})
+
+	return len(payload), nil
+}
+
+// TestRebindContinuesSequence writes through the media writer of a binding
+// and then through the one of a rebind to another codec and ptime, as
+// RebindRTP does, and checks the peer sees one stream.
+func TestRebindContinuesSequence(t *testing.T) {
+	out := &packetBuffer{}
+	seqWriter := rtp.NewSeqWriter(out)
+
+	writeFrames := func(w *mediaWriter[media.Writer[media.PCM16Sample]], n, samples int) {
+		for range n {
+			if err := w.WriteSample(make(media.PCM16Sample, samples)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	prev, err := newMediaWriter(seqWriter, Codec{Name: CodecPCMU, PayloadType: PayloadTypePCMU, ClockRate: 8000}, 20, 8000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFrames(prev, 3, 160)
+
+	next, err := newMediaWriter(seqWriter, Codec{Name: CodecPCMA, PayloadType: PayloadTypePCMA, ClockRate: 8000}, 30, 8000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next.ContinueFrom(prev)
+	writeFrames(next, 2, 240)
+
+	want := []struct {
+		pt     byte
+		ts     uint32
+		marker bool
+	}{
+		{pt: PayloadTypePCMU, ts: 0, marker: true},
+		{pt: PayloadTypePCMU, ts: 160},
+		{pt: PayloadTypePCMU, ts: 320},
+		// the new codec starts a talkspurt where the previous one stopped
+		{pt: PayloadTypePCMA, ts: 480, marker: true},
+		{pt: PayloadTypePCMA, ts: 720},
+	}
+
+	if len(out.packets) != len(want) {
+		t.Fatalf("%d packets, want %d", len(out.packets), len(want))
+	}
+
+	first := out.packets[0]
+
+	for i, w := range want {
+		pkt := out.packets[i]
+
+		if pkt.SSRC != first.SSRC {
+			t.Errorf("packet %d: SSRC %d, want %d", i, pkt.SSRC, first.SSRC)
+		}
+
+		if pkt.SequenceNumber != first.SequenceNumber+uint16(i) {
+			t.Errorf("packet %d: seq %d, want %d", i, pkt.SequenceNumber, first.SequenceNumber+uint16(i))
+		}
+
+		if pkt.PayloadType != w.pt || pkt.Timestamp-first.Timestamp != w.ts || pkt.Marker != w.marker {
+			t.Errorf("packet %d: pt %d ts +%d marker %v, want pt %d ts +%d marker %v",
+				i, pkt.PayloadType, pkt.Timestamp-first.Timestamp, pkt.Marker, w.pt, w.ts, w.marker)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package rtp
+
+import (
+	"context"
+	"time"
+
+	"github.com/livekit/media-sdk/rtp"
+	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/pion/webrtc/v4"
+)
+
+// WithReconnectBuffer keeps up to d of the latest audio of the RTP peer
+// while the room reconnects, and plays it into the room once reconnected,
+// at the cost of up to d more delay. By default that audio is discarded, so
+// the room hears the peer live again as soon as the room is back.
+func WithReconnectBuffer(d time.Duration) ManagerOption {
+	return func(r *Manager) {
+		r.reconnectBuffer = max(d, 0)
+	}
+}
+
+// reconnecting holds the RTP from the peer back until the room is back.
+//
+// The LiveKit SDK resumes a dropped room connection by itself, or restarts
+// it when it cannot resume: the remote tracks are then subscribed again and
+// the local tracks published again, under new publications. When it gives
+// up, the room reports a disconnection and the session is hung up.
+func (s *session) reconnecting() {
+	s.mx.Lock()
+
+	if s.roomReconnecting {
+		s.mx.Unlock()
+
+		return
+	}
+
+	s.roomReconnecting = true
+
+	if s.streamRTP != nil {
+		s.streamRTP.pause(s.reconnectBuffer)
+	}
+
+	s.mx.Unlock()
+
+	s.log.Infow("room reconnecting", "buffer", s.reconnectBuffer)
+	s.events.OnReconnecting(s.id)
+}
+
+// reconnected lets the RTP from the peer through again. It reports whether
+// the room was reconnecting, restore is due then.
+func (s *session) reconnected() bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if !s.roomReconnecting {
+		return false
+	}
+
+	s.roomReconnecting = false
+
+	if s.streamRTP != nil {
+		s.streamRTP.resume()
+	}
+
+	return true
+}
+
+// restore takes over what a restart of the room connection changed. The
+// track is published again if the SDK failed to, and the session is hung up
+// if that fails too. It may publish, so it does not run on the goroutine of
+// the SDK reconnecting the room.
+func (s *session) restore() {
+	s.ops.Lock()
+	defer s.ops.Unlock()
+
+	s.mx.Lock()
+	track, publication := s.track, s.publication
+	mix, channels := s.mixer, s.channels
+	s.mx.Unlock()
+
+	if err := s.expect(opReconnect, StateConnected, StateBound, StateActive); err != nil {
+		return
+	}
+
+	s.log.Infow("room reconnected")
+
+	if publication != nil {
+		if err := s.republish(track, publication); err != nil {
+			s.log.Warnw("failed to publish track again", err, "trackID", track.ID())
+			s.hangup(EndReasonReconnectFailed)
+
+			return
+		}
+	}
+
+	for _, p := range s.getPlaybacks() {
+		p.republished(s.room.LocalParticipant)
+	}
+
+	// tracks subscribed again after a restart attach themselves, the others
+	// restart decoding after the gap
+	if mix != nil {
+		for _, input := range s.getInputs() {
+			if err := input.attach(mix, channels); err != nil {
+				s.log.Warnw("failed to reattach track", err, "trackID", input.id, "participant", input.identity)
+			}
+		}
+	}
+
+	s.events.OnReconnected(s.id)
+}
+
+// republish takes over the publication the SDK published track under after
+// a restart, or publishes it again if the SDK failed to.
+func (s *session) republish(track *lksdk.LocalTrack, prev *lksdk.LocalTrackPublication) error {
+	lp := s.room.LocalParticipant
+
+	publication := findPublication(lp, track)
+	if publication == prev {
+		return nil
+	}
+
+	if publication == nil {
+		opts := prev.PublicationOptions()
+
+		var err error
+		if publication, err = publishTrack(context.Background(), lp, track, &opts); err != nil {
+			return err
+		}
+	}
+
+	s.log.Infow("track published again", "trackID", track.ID(), "trackSID", publication.SID())
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.publication = publication
+	s.applyDirectionLocked()
+
+	return nil
+}
+
+// republished picks up the publication the SDK gave the track of the
+// playback after a restart.
+func (p *Playback) republished(lp *lksdk.LocalParticipant) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.track == nil {
+		return
+	}
+
+	publication := findPublication(lp, p.track)
+	if publication == nil {
+		p.log.Warnw("playback track is no longer published", nil, "trackSID", p.trackSID)
+
+		return
+	}
+
+	p.trackSID = publication.SID()
+}
+
+// findPublication returns the publication of track by lp, nil if it is not published.
+func findPublication(lp *lksdk.LocalParticipant, track webrtc.TrackLocal) *lksdk.LocalTrackPublication {
+	for _, pub := range lp.TrackPublications() {
+		if pub, ok := pub.(*lksdk.LocalTrackPublication); ok && pub.TrackLocal() == track {
+			return pub
+		}
+	}
+
+	return nil
+}
+
+type heldPacket struct {
+	pkt     rtp.Packet
+	arrival time.Time
+}
+
+// pause holds the RTP from the peer back from the rtpSampleProvider until
+// resume, keeping the packets of the last buffer and discarding older ones.
+func (c *streamRTP) pause(buffer time.Duration) {
+	c.pauseMx.Lock()
+	defer c.pauseMx.Unlock()
+
+	c.paused, c.pauseBuffer = true, buffer
+}
+
+// resume lets the RTP through again, the packets held back first.
+func (c *streamRTP) resume() {
+	c.pauseMx.Lock()
+	defer c.pauseMx.Unlock()
+
+	c.paused = false
+}
+
+// deliver hands the packets leaving the jitter buffer to the rtpSampleProvider.
+func (c *streamRTP) deliver(pkt *rtp.Packet) {
+	c.pauseMx.Lock()
+
+	if c.paused {
+		c.hold(pkt)
+		c.pauseMx.Unlock()
+
+		return
+	}
+
+	held := c.heldBack
+	c.heldBack = nil
+	c.pauseMx.Unlock()
+
+	for _, h := range held {
+		c.rtpBuff <- h.pkt
+	}
+
+	c.rtpBuff <- *pkt
+}
+
+func (c *streamRTP) hold(pkt *rtp.Packet) {
+	if c.pauseBuffer <= 0 {
+		return
+	}
+
+	now := time.Now()
+	c.heldBack = append(c.heldBack, heldPacket{pkt: *pkt, arrival: now})
+
+	expired := 0
+	for expired < len(c.heldBack) && now.Sub(c.heldBack[expired].arrival) > c.pauseBuffer {
+		expired++
+	}
+
+	c.heldBack = c.heldBack[expired:]
+}
@@ -0,0 +1,123 @@
+package rtp
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/livekit/media-sdk/rtp"
+	"github.com/livekit/protocol/logger"
+)
+
+func TestStreamRTPPause(t *testing.T) {
+	c := &streamRTP{rtpBuff: make(chan rtp.Packet, 16)}
+
+	deliver := func(seq uint16) {
+		c.deliver(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}})
+	}
+
+	deliver(1)
+
+	c.pause(100 * time.Millisecond)
+	deliver(2)
+	deliver(3)
+
+	if len(c.rtpBuff) != 1 {
+		t.Fatalf("%d packets delivered while paused, want only the one before", len(c.rtpBuff))
+	}
+
+	// 2 and 3 are older than the buffer by then
+	time.Sleep(150 * time.Millisecond)
+	deliver(4)
+
+	c.resume()
+	deliver(5)
+
+	// without a buffer nothing is kept
+	c.pause(0)
+	deliver(6)
+	c.resume()
+	deliver(7)
+
+	close(c.rtpBuff)
+
+	var seqs []uint16
+	for pkt := range c.rtpBuff {
+		seqs = append(seqs, pkt.SequenceNumber)
+	}
+
+	if want := []uint16{1, 4, 5, 7}; !slices.Equal(seqs, want) {
+		t.Errorf("delivered %v, want %v", seqs, want)
+	}
+}
+
+// reconnectEvents counts the reconnect events of the sessions.
+type reconnectEvents struct {
+	NopEvents
+
+	reconnecting, reconnected int
+}
+
+func (e *reconnectEvents) OnReconnecting(string) {
+	e.reconnecting++
+}
+
+func (e *reconnectEvents) OnReconnected(string) {
+	e.reconnected++
+}
+
+func TestSessionReconnect(t *testing.T) {
+	events := &reconnectEvents{}
+	stream := &streamRTP{rtpBuff: make(chan rtp.Packet, 16)}
+
+	s := &session{
+		id:              "s1",
+		state:           StateActive,
+		events:          events,
+		log:             logger.GetLogger(),
+		streamRTP:       stream,
+		reconnectBuffer: time.Second,
+	}
+
+	// the SDK reports every attempt
+	s.reconnecting()
+	s.reconnecting()
+
+	if events.reconnecting != 1 {
+		t.Errorf("OnReconnecting called %d times, want once", events.reconnecting)
+	}
+
+	stream.deliver(&rtp.Packet{})
+
+	if len(stream.rtpBuff) != 0 {
+		t.Error("RTP delivered while reconnecting")
+	}
+
+	if !s.reconnected() {
+		t.Fatal("not reconnecting")
+	}
+
+	if s.reconnected() {
+		t.Error("reconnected twice")
+	}
+
+	stream.deliver(&rtp.Packet{})
+
+	if len(stream.rtpBuff) != 2 {
+		t.Errorf("%d packets delivered after reconnecting, want the one held back and the new one", len(stream.rtpBuff))
+	}
+
+	s.restore()
+
+	if events.reconnected != 1 {
+		t.Errorf("OnReconnected called %d times, want once", events.reconnected)
+	}
+
+	// a session closed in the meantime is not reported
+	s.state = StateClosed
+	s.restore()
+
+	if events.reconnected != 1 {
+		t.Errorf("OnReconnected called %d times for a closed session, want once", events.reconnected)
+	}
+}
@@ -0,0 +1,262 @@
+package rtp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"os"
+
+	"github.com/livekit/media-sdk/rtp"
+	opusv2 "gopkg.in/hraban/opus.v2"
+)
+
+const (
+	wavHeaderSize = 44
+
+	// oggPreSkip is the lookahead of the libopus encoder at 48 kHz, RFC 7845 4.2.
+	oggPreSkip = 312
+
+	oggHeaderBOS = 0x02
+	oggHeaderEOS = 0x04
+)
+
+// wavSink writes 16-bit stereo WAV. The sizes in the header are filled in by Close.
+type wavSink struct {
+	f          *os.File
+	w          *bufio.Writer
+	sampleRate int
+	buf        []byte
+	size       int
+}
+
+func newWAVSink(path string, sampleRate int) (*wavSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &wavSink{
+		f:          f,
+		w:          bufio.NewWriter(f),
+		sampleRate: sampleRate,
+	}
+
+	if _, err := s.w.Write(wavHeader(sampleRate, 0)); err != nil {
+		f.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func wavHeader(sampleRate, size int) []byte {
+	const channels, bytesPerSample = 2, 2
+
+	h := make([]byte, 0, wavHeaderSize)
+	h = append(h, "RIFF"...)
+	h = binary.LittleEndian.AppendUint32(h, uint32(wavHeaderSize-8+size))
+	h = append(h, "WAVEfmt "...)
+	h = binary.LittleEndian.AppendUint32(h, 16)
+	h = binary.LittleEndian.AppendUint16(h, 1) // PCM
+	h = binary.LittleEndian.AppendUint16(h, channels)
+	h = binary.LittleEndian.AppendUint32(h, uint32(sampleRate))
+	h = binary.LittleEndian.AppendUint32(h, uint32(sampleRate*channels*bytesPerSample))
+	h = binary.LittleEndian.AppendUint16(h, channels*bytesPerSample)
+	h = binary.LittleEndian.AppendUint16(h, 8*bytesPerSample)
+	h = append(h, "data"...)
+	h = binary.LittleEndian.AppendUint32(h, uint32(size))
+
+	return h
+}
+
+func (s *wavSink) WriteFrame(stereo []int16) error {
+	s.buf = s.buf[:0]
+	for _, v := range stereo {
+		s.buf = binary.LittleEndian.AppendUint16(s.buf, uint16(v))
+	}
+
+	n, err := s.w.Write(s.buf)
+	s.size += n
+
+	return err
+}
+
+func (s *wavSink) Close() error {
+	err := s.w.Flush()
+	if err == nil {
+		_, err = s.f.WriteAt(wavHeader(s.sampleRate, s.size), 0)
+	}
+
+	if errClose := s.f.Close(); err == nil {
+		err = errClose
+	}
+
+	return err
+}
+
+// oggOpusSink writes a stereo Ogg Opus file, RFC 7845, one packet per page.
+// The last packet is held back, so its page can carry the end of stream flag.
+type oggOpusSink struct {
+	f       *os.File
+	w       *bufio.Writer
+	encoder *opusv2.Encoder
+
+	serial   uint32
+	sequence uint32
+	granule  uint64
+	// frameGranule is the duration of a frame at 48 kHz, the Ogg Opus granule rate.
+	frameGranule uint64
+
+	data    []byte
+	pending []byte
+}
+
+func newOggOpusSink(path string, sampleRate int) (*oggOpusSink, error) {
+	encoder, err := opusv2.NewEncoder(sampleRate, 2, opusv2.AppVoIP)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &oggOpusSink{
+		f:            f,
+		w:            bufio.NewWriter(f),
+		encoder:      encoder,
+		serial:       rand.Uint32(),
+		granule:      oggPreSkip,
+		frameGranule: uint64(publishSampleRate / rtp.DefFramesPerSec),
+		data:         make([]byte, inboundMTU),
+	}
+
+	head := []byte("OpusHead")
+	head = append(head, 1, 2) // version, channels
+	head = binary.LittleEndian.AppendUint16(head, oggPreSkip)
+	head = binary.LittleEndian.AppendUint32(head, uint32(sampleRate))
+	head = binary.LittleEndian.AppendUint16(head, 0) // output gain
+	head = append(head, 0)                           // channel mapping family
+
+	tags := []byte("OpusTags")
+	tags = binary.LittleEndian.AppendUint32(tags, uint32(len(sdpSessionName)))
+	tags = append(tags, sdpSessionName...)
+	tags = binary.LittleEndian.AppendUint32(tags, 0) // no comments
+
+	if err := s.writePage(head, 0, oggHeaderBOS); err != nil {
+		f.Close()
+
+		return nil, err
+	}
+
+	if err := s.writePage(tags, 0, 0); err != nil {
+		f.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *oggOpusSink) WriteFrame(stereo []int16) error {
+	n, err := s.encoder.Encode(stereo, s.data)
+	if err != nil {
+		return fmt.Errorf("failed to encode: %w", err)
+	}
+
+	if err := s.flushPending(0); err != nil {
+		return err
+	}
+
+	s.pending = append(s.pending[:0], s.data[:n]...)
+
+	return nil
+}
+
+// flushPending writes the held back packet, if any.
+func (s *oggOpusSink) flushPending(flags byte) error {
+	if s.pending == nil {
+		return nil
+	}
+
+	s.granule += s.frameGranule
+
+	return s.writePage(s.pending, s.granule, flags)
+}
+
+func (s *oggOpusSink) Close() error {
+	if s.pending == nil {
+		// an empty stream still ends with an EOS page
+		s.pending = []byte{}
+		s.granule -= s.frameGranule
+	}
+
+	err := s.flushPending(oggHeaderEOS)
+	if err == nil {
+		err = s.w.Flush()
+	}
+
+	if errClose := s.f.Close(); err == nil {
+		err = errClose
+	}
+
+	return err
+}
+
+// writePage writes packet as a page of its own.
+func (s *oggOpusSink) writePage(packet []byte, granule uint64, flags byte) error {
+	page := make([]byte, 0, 27+len(packet)/255+1+len(packet))
+	page = append(page, "OggS"...)
+	page = append(page, 0, flags)
+	page = binary.LittleEndian.AppendUint64(page, granule)
+	page = binary.LittleEndian.AppendUint32(page, s.serial)
+	page = binary.LittleEndian.AppendUint32(page, s.sequence)
+	page = binary.LittleEndian.AppendUint32(page, 0) // checksum, below
+
+	// lacing: 255 per full segment, then the remainder, 0 if it divides evenly
+	page = append(page, byte(len(packet)/255+1))
+	for range len(packet) / 255 {
+		page = append(page, 255)
+	}
+
+	page = append(page, byte(len(packet)%255))
+	page = append(page, packet...)
+
+	binary.LittleEndian.PutUint32(page[22:], oggChecksum(page))
+
+	s.sequence++
+
+	_, err := s.w.Write(page)
+
+	return err
+}
+
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+
+		table[i] = r
+	}
+
+	return table
+}()
+
+// oggChecksum is the CRC-32 of an Ogg page, polynomial 0x04c11db7 without reflection.
+func oggChecksum(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+
+	return crc
+}
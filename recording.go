@@ -0,0 +1,305 @@
+package rtp
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livekit/media-sdk"
+	"github.com/livekit/media-sdk/rtp"
+	"github.com/livekit/protocol/logger"
+)
+
+const (
+	defaultRecordSampleRate = 48000
+
+	// maxRecordLag is how far, in frames, one direction may run ahead of the
+	// other before its oldest audio is dropped.
+	maxRecordLag = 10
+)
+
+// RecordFormat is the file format of a recording.
+type RecordFormat string
+
+const (
+	RecordWAV     = RecordFormat("wav")
+	RecordOggOpus = RecordFormat("ogg")
+)
+
+// RecordConfig describes a recording started with StartRecording.
+type RecordConfig struct {
+	Path string
+	// Format defaults to RecordOggOpus for a .ogg or .opus Path, RecordWAV otherwise.
+	Format RecordFormat
+	// SampleRate is one of 8000, 12000, 16000, 24000 or 48000, the default.
+	SampleRate int
+}
+
+// recordSink receives the interleaved stereo frames of a recording.
+type recordSink interface {
+	WriteFrame(stereo []int16) error
+	Close() error
+}
+
+// Recording records a session to a stereo file: the caller, as published to
+// the room, on the left and the room, as sent to the caller, on the right.
+type Recording struct {
+	path       string
+	session    *session
+	log        logger.Logger
+	sampleRate int
+	sink       recordSink
+
+	mx       sync.Mutex
+	caller   media.PCM16Sample
+	room     media.PCM16Sample
+	toCaller media.PCM16Writer
+	toRoom   media.PCM16Writer
+	paused   bool
+	samples  int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	err      error
+
+	resampleErrors logLimit
+}
+
+// StartRecording records the session into conf.Path until Recording.Stop is
+// called or the session ends. Events.OnRecordingFinished reports the file.
+func (r *Manager) StartRecording(ctx context.Context, sID string, conf RecordConfig) (*Recording, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	session, ok := r.getSession(sID)
+
+	if !ok {
+		return nil, fmt.Errorf("session %s: %w", sID, ErrNotFound)
+	}
+
+	if err := session.expect(opRecord, StateConnected, StateBound, StateActive); err != nil {
+		return nil, err
+	}
+
+	if conf.SampleRate == 0 {
+		conf.SampleRate = defaultRecordSampleRate
+	}
+
+	switch conf.SampleRate {
+	case 8000, 12000, 16000, 24000, 48000:
+	default:
+		return nil, fmt.Errorf("session %s: unsupported recording sample rate %d", sID, conf.SampleRate)
+	}
+
+	if conf.Format == "" {
+		switch strings.ToLower(filepath.Ext(conf.Path)) {
+		case ".ogg", ".opus":
+			conf.Format = RecordOggOpus
+		default:
+			conf.Format = RecordWAV
+		}
+	}
+
+	// checked before the file is created, which would truncate a recording in progress
+	session.recordMx.Lock()
+	defer session.recordMx.Unlock()
+
+	if session.recording.Load() != nil {
+		return nil, fmt.Errorf("session %s: already recording", sID)
+	}
+
+	var (
+		sink recordSink
+		err  error
+	)
+
+	switch conf.Format {
+	case RecordWAV:
+		sink, err = newWAVSink(conf.Path, conf.SampleRate)
+	case RecordOggOpus:
+		sink, err = newOggOpusSink(conf.Path, conf.SampleRate)
+	default:
+		return nil, fmt.Errorf("session %s: unsupported recording format %q", sID, conf.Format)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("session %s: failed to create recording: %w", sID, err)
+	}
+
+	rec := &Recording{
+		path:       conf.Path,
+		session:    session,
+		log:        session.log.WithValues("path", conf.Path),
+		sampleRate: conf.SampleRate,
+		sink:       sink,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	rec.toCaller = media.ResampleWriter(media.NewPCM16BufferWriter(&rec.caller, conf.SampleRate), publishSampleRate)
+	rec.toRoom = media.ResampleWriter(media.NewPCM16BufferWriter(&rec.room, conf.SampleRate), session.mixerSampleRate)
+
+	session.recording.Store(rec)
+
+	rec.log.Infow("started recording", "format", conf.Format, "sampleRate", conf.SampleRate)
+
+	go rec.run()
+
+	return rec, nil
+}
+
+// Path is the file the recording is written to.
+func (rec *Recording) Path() string {
+	return rec.path
+}
+
+// Duration is how much has been recorded so far.
+func (rec *Recording) Duration() time.Duration {
+	rec.mx.Lock()
+	defer rec.mx.Unlock()
+
+	return time.Duration(rec.samples) * time.Second / time.Duration(rec.sampleRate)
+}
+
+// Pause stops writing to the file until Resume; the pause is left out of it.
+func (rec *Recording) Pause() {
+	rec.mx.Lock()
+	defer rec.mx.Unlock()
+
+	rec.paused = true
+}
+
+// Resume continues a paused recording.
+func (rec *Recording) Resume() {
+	rec.mx.Lock()
+	defer rec.mx.Unlock()
+
+	rec.paused = false
+}
+
+// Stop ends the recording and returns once the file is complete.
+func (rec *Recording) Stop() error {
+	rec.stopOnce.Do(func() {
+		close(rec.stop)
+	})
+
+	<-rec.done
+
+	return rec.err
+}
+
+// writeCaller takes PCM of the caller at publishSampleRate.
+func (rec *Recording) writeCaller(pcm media.PCM16Sample) {
+	rec.mx.Lock()
+	defer rec.mx.Unlock()
+
+	if err := rec.toCaller.WriteSample(pcm); err != nil {
+		rec.resampleErrors.Debugw(rec.log, "failed to resample the caller", "error", err)
+	}
+}
+
+// writeRoom takes PCM of the room at the rate of the mixer.
+func (rec *Recording) writeRoom(pcm media.PCM16Sample) {
+	rec.mx.Lock()
+	defer rec.mx.Unlock()
+
+	if err := rec.toRoom.WriteSample(pcm); err != nil {
+		rec.resampleErrors.Debugw(rec.log, "failed to resample the room", "error", err)
+	}
+}
+
+// run writes a stereo frame every frame duration. Both directions arrive at
+// their own pace, a direction that has nothing is recorded as silence.
+func (rec *Recording) run() {
+	ticker := time.NewTicker(rtp.DefFrameDur)
+	defer ticker.Stop()
+
+	frame := rec.sampleRate / rtp.DefFramesPerSec
+	stereo := make([]int16, 2*frame)
+
+	for {
+		select {
+		case <-rec.stop:
+			rec.finish()
+
+			return
+		case <-ticker.C:
+		}
+
+		if err := rec.writeFrame(stereo, frame); err != nil {
+			rec.err = fmt.Errorf("failed to write recording: %w", err)
+			rec.finish()
+
+			return
+		}
+	}
+}
+
+func (rec *Recording) writeFrame(stereo []int16, frame int) error {
+	rec.mx.Lock()
+	defer rec.mx.Unlock()
+
+	clear(stereo)
+
+	left := takeFrame(&rec.caller, frame, maxRecordLag*frame)
+	right := takeFrame(&rec.room, frame, maxRecordLag*frame)
+
+	for i := range left {
+		stereo[2*i] = left[i]
+	}
+
+	for i := range right {
+		stereo[2*i+1] = right[i]
+	}
+
+	if rec.paused {
+		return nil
+	}
+
+	rec.samples += frame
+
+	return rec.sink.WriteFrame(stereo)
+}
+
+// takeFrame removes up to frame samples from buf, after dropping what lags
+// more than maxLag behind.
+func takeFrame(buf *media.PCM16Sample, frame, maxLag int) []int16 {
+	if over := len(*buf) - maxLag; over > 0 {
+		n := copy(*buf, (*buf)[over:])
+		*buf = (*buf)[:n]
+	}
+
+	n := min(frame, len(*buf))
+	out := make([]int16, n)
+	copy(out, *buf)
+
+	rest := copy(*buf, (*buf)[n:])
+	*buf = (*buf)[:rest]
+
+	return out
+}
+
+func (rec *Recording) finish() {
+	rec.session.recording.CompareAndSwap(rec, nil)
+
+	if err := rec.sink.Close(); err != nil && rec.err == nil {
+		rec.err = fmt.Errorf("failed to close recording: %w", err)
+	}
+
+	duration := rec.Duration()
+
+	if rec.err != nil {
+		rec.log.Warnw("recording failed", rec.err)
+	}
+
+	rec.log.Infow("finished recording", "duration", duration)
+
+	close(rec.done)
+
+	rec.session.events.OnRecordingFinished(rec.session.id, rec.path, duration)
+}
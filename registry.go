@@ -0,0 +1,31 @@
+package rtp
+
+import "sync"
+
+// sessionRegistry maps session IDs to their sessions. Lookups, by far the
+// most frequent, share no lock, so calls on different sessions do not
+// contend; each session guards its own state.
+type sessionRegistry struct {
+	sessions sync.Map
+}
+
+func (r *sessionRegistry) load(sID string) (*session, bool) {
+	v, ok := r.sessions.Load(sID)
+	if !ok {
+		return nil, false
+	}
+
+	return v.(*session), true
+}
+
+// add registers s, unless its ID is taken already.
+func (r *sessionRegistry) add(s *session) bool {
+	_, loaded := r.sessions.LoadOrStore(s.id, s)
+
+	return !loaded
+}
+
+// remove unregisters s, if it is still the session registered under its ID.
+func (r *sessionRegistry) remove(s *session) bool {
+	return r.sessions.CompareAndDelete(s.id, s)
+}
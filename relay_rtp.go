@@ -0,0 +1,42 @@
+package rtp
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/livekit/media-sdk/rtp"
+)
+
+// relayRTP forwards outbound RTP to whichever streamRTP is currently bound,
+// so the rtp.SeqWriter in front of it survives a rebind. It stamps its own
+// SSRC on every packet, the one announced in our RTCP reports.
+type relayRTP struct {
+	ssrc   uint32
+	stream atomic.Pointer[streamRTP]
+}
+
+func newRelayRTP(ssrc uint32) *relayRTP {
+	return &relayRTP{
+		ssrc: ssrc,
+	}
+}
+
+func (r *relayRTP) SetStream(stream *streamRTP) {
+	r.stream.Store(stream)
+}
+
+func (r *relayRTP) String() string {
+	return "relay RTP"
+}
+
+func (r *relayRTP) WriteRTP(h *rtp.Header, payload []byte) (int, error) {
+	stream := r.stream.Load()
+	if stream == nil {
+		return 0, io.ErrClosedPipe
+	}
+
+	header := *h
+	header.SSRC = r.ssrc
+
+	return stream.WriteRTP(&header, payload)
+}
@@ -0,0 +1,181 @@
+package rtp
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+const (
+	// rtcpInterval is the RFC 3550 minimum report interval, randomized by nextReportInterval.
+	rtcpInterval = 5 * time.Second
+
+	// maxTotalLost is the largest cumulative loss a reception report can carry (24 bits, signed).
+	maxTotalLost = 1<<23 - 1
+)
+
+// newCNAME returns a random CNAME for a session, 96 bits as in RFC 7022, so
+// our RTCP does not give the session ID away to the peer.
+func newCNAME() string {
+	var b [12]byte
+	_, _ = cryptorand.Read(b[:])
+
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// nextReportInterval spreads reports over [0.5, 1.5] times rtcpInterval, as in RFC 3550 6.3.1.
+func nextReportInterval() time.Duration {
+	return time.Duration((0.5 + rand.Float64()) * float64(rtcpInterval))
+}
+
+// Report builds a compound RTCP packet for the peer: an SR if we have sent
+// media, an RR otherwise, followed by an SDES with our CNAME.
+func (s *streamStats) Report(now time.Time) []rtcp.Packet {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.meterLocked()
+
+	var reports []rtcp.ReceptionReport
+	if s.started {
+		reports = append(reports, s.receptionReportLocked(now))
+	}
+
+	var report rtcp.Packet
+	if s.packetsOut > 0 {
+		report = &rtcp.SenderReport{
+			SSRC:        s.ssrcOut,
+			NTPTime:     ntpTime(now),
+			RTPTime:     s.rtpTimeLocked(now),
+			PacketCount: uint32(s.packetsOut),
+			OctetCount:  uint32(s.bytesOut),
+			Reports:     reports,
+		}
+	} else {
+		report = &rtcp.ReceiverReport{
+			SSRC:    s.ssrcOut,
+			Reports: reports,
+		}
+	}
+
+	return []rtcp.Packet{
+		report,
+		rtcp.NewCNAMESourceDescription(s.ssrcOut, s.cname),
+	}
+}
+
+// Bye builds the compound RTCP packet announcing that our SSRC leaves.
+func (s *streamStats) Bye(now time.Time, reason string) []rtcp.Packet {
+	return append(s.Report(now), &rtcp.Goodbye{
+		Sources: []uint32{s.ssrcOut},
+		Reason:  reason,
+	})
+}
+
+// rtpTimeLocked extrapolates the RTP timestamp of the last sent packet to now.
+func (s *streamStats) rtpTimeLocked(now time.Time) uint32 {
+	if s.lastOutAt.IsZero() || s.clockRate == 0 {
+		return s.lastTSOut
+	}
+
+	elapsed := now.Sub(s.lastOutAt)
+
+	return s.lastTSOut + uint32(int64(elapsed)*int64(s.clockRate)/int64(time.Second))
+}
+
+func (s *streamStats) receptionReportLocked(now time.Time) rtcp.ReceptionReport {
+	lost := s.lostPrevious + s.lostLocked()
+	if lost > maxTotalLost {
+		lost = maxTotalLost
+	}
+
+	if lost < 0 {
+		lost = 0
+	}
+
+	expected := uint64(s.cycles) + uint64(s.maxSeq) - uint64(s.baseSeq) + 1
+	expectedInterval := expected - s.expectedPrior
+	receivedInterval := s.received - s.receivedPrior
+	s.expectedPrior, s.receivedPrior = expected, s.received
+
+	var fractionLost uint8
+	if expectedInterval > 0 && expectedInterval > receivedInterval {
+		fractionLost = uint8((expectedInterval - receivedInterval) << 8 / expectedInterval)
+	}
+
+	var delay uint32
+	if !s.lastSRAt.IsZero() {
+		delay = uint32(now.Sub(s.lastSRAt) * 65536 / time.Second)
+	}
+
+	return rtcp.ReceptionReport{
+		SSRC:               s.ssrcIn,
+		FractionLost:       fractionLost,
+		TotalLost:          uint32(lost),
+		LastSequenceNumber: s.cycles + uint32(s.maxSeq),
+		Jitter:             uint32(s.jitter),
+		LastSenderReport:   s.lastSR,
+		Delay:              delay,
+	}
+}
+
+// sendReports periodically sends our reports to the peer until the stream is closed.
+func (c *streamRTP) sendReports() {
+	timer := time.NewTimer(nextReportInterval())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-timer.C:
+		}
+
+		if err := c.sendRTCP(c.stats.Report(time.Now())); err != nil && !shouldExit(err) {
+			c.log.Warnw("failed to send RTCP report", err, "remoteAddr", c.remoteAddr())
+		}
+
+		timer.Reset(nextReportInterval())
+	}
+}
+
+// SendBye tells the peer that our SSRC leaves the session.
+func (c *streamRTP) SendBye(reason string) {
+	if err := c.sendRTCP(c.stats.Bye(time.Now(), reason)); err != nil && !shouldExit(err) {
+		c.log.Warnw("failed to send RTCP BYE", err, "remoteAddr", c.remoteAddr())
+	}
+}
+
+func (c *streamRTP) sendRTCP(pkts []rtcp.Packet) error {
+	c.rAddrRTCPMx.Lock()
+	rAddr := c.rAddrRTCP
+	c.rAddrRTCPMx.Unlock()
+
+	// nothing heard from the peer yet and no address was given
+	if rAddr == nil {
+		return nil
+	}
+
+	data, err := rtcp.Marshal(pkts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal RTCP: %w", err)
+	}
+
+	if c.srtp != nil {
+		if data, err = c.srtp.EncryptRTCP(data); err != nil {
+			return fmt.Errorf("failed to encrypt RTCP: %w", err)
+		}
+	}
+
+	if _, err := c.connRTCP.WriteTo(data, rAddr); err != nil {
+		return fmt.Errorf("failed to write RTCP: %w", err)
+	}
+
+	c.stats.OnRTCPOut(len(pkts))
+
+	return nil
+}
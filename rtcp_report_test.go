@@ -0,0 +1,183 @@
+package rtp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livekit/media-sdk/rtp"
+	"github.com/pion/rtcp"
+)
+
+func TestReportReceiverReport(t *testing.T) {
+	s := newStreamStats(1, "cname", nil)
+	s.SetClockRate(8000)
+
+	pkts := s.Report(time.Now())
+	if len(pkts) != 2 {
+		t.Fatalf("%d packets, want RR and SDES", len(pkts))
+	}
+
+	rr, ok := pkts[0].(*rtcp.ReceiverReport)
+	if !ok || rr.SSRC != 1 || len(rr.Reports) != 0 {
+		t.Fatalf("first packet %+v, want an RR of SSRC 1 without reports before any RTP", pkts[0])
+	}
+
+	sdes, ok := pkts[1].(*rtcp.SourceDescription)
+	if !ok || len(sdes.Chunks) != 1 || sdes.Chunks[0].Source != 1 ||
+		len(sdes.Chunks[0].Items) != 1 || sdes.Chunks[0].Items[0].Type != rtcp.SDESCNAME || sdes.Chunks[0].Items[0].Text != "cname" {
+		t.Fatalf("second packet %+v, want the SDES CNAME of SSRC 1", pkts[1])
+	}
+
+	// 10 is lost, and the sequence wraps
+	now := time.Now()
+	for i, seq := range []uint16{65534, 65535, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 11} {
+		s.OnRTPIn(&rtp.Header{SSRC: 2, SequenceNumber: seq, Timestamp: uint32(i) * 160}, 160, now.Add(time.Duration(i)*20*time.Millisecond))
+	}
+
+	cases := []struct {
+		name         string
+		fractionLost uint8
+		totalLost    uint32
+	}{
+		{name: "first report", fractionLost: 256 / 14, totalLost: 1},
+		{name: "nothing new", fractionLost: 0, totalLost: 1},
+	}
+
+	for _, tc := range cases {
+		rr, ok := s.Report(time.Now())[0].(*rtcp.ReceiverReport)
+		if !ok || len(rr.Reports) != 1 {
+			t.Fatalf("%s: %+v, want an RR with one report", tc.name, rr)
+		}
+
+		report := rr.Reports[0]
+		if report.SSRC != 2 || report.FractionLost != tc.fractionLost || report.TotalLost != tc.totalLost || report.LastSequenceNumber != 1<<16+11 {
+			t.Errorf("%s: report %+v, want SSRC 2, fraction lost %d, total lost %d, last seq %d",
+				tc.name, report, tc.fractionLost, tc.totalLost, 1<<16+11)
+		}
+	}
+}
+
+func TestReportSSRCChange(t *testing.T) {
+	s := newStreamStats(1, "cname", nil)
+
+	now := time.Now()
+	for seq := range uint16(10) {
+		s.OnRTPIn(&rtp.Header{SSRC: 2, SequenceNumber: seq}, 160, now)
+	}
+
+	s.Report(now)
+
+	// the peer restarts its stream with a new SSRC, and loses 102
+	for _, seq := range []uint16{100, 101, 103, 104, 105} {
+		s.OnRTPIn(&rtp.Header{SSRC: 3, SequenceNumber: seq}, 160, now)
+	}
+
+	rr, ok := s.Report(now)[0].(*rtcp.ReceiverReport)
+	if !ok || len(rr.Reports) != 1 {
+		t.Fatalf("%+v, want an RR with one report", rr)
+	}
+
+	report := rr.Reports[0]
+	if report.SSRC != 3 || report.FractionLost != 256/6 || report.TotalLost != 1 || report.LastSequenceNumber != 105 {
+		t.Errorf("report %+v, want SSRC 3, fraction lost %d, total lost 1, last seq 105", report, 256/6)
+	}
+}
+
+func TestReportSenderReport(t *testing.T) {
+	s := newStreamStats(1, "cname", nil)
+	s.SetClockRate(8000)
+
+	for i := range 3 {
+		s.OnRTPOut(&rtp.Header{SSRC: 1, SequenceNumber: uint16(i), Timestamp: 1000 + uint32(i)*160}, 160)
+	}
+
+	// an SR of the peer to echo
+	srAt := time.Now()
+	s.OnRTCPIn([]rtcp.Packet{&rtcp.SenderReport{SSRC: 2, NTPTime: ntpTime(srAt)}}, srAt)
+	s.OnRTPIn(&rtp.Header{SSRC: 2, SequenceNumber: 1}, 160, srAt)
+
+	now := time.Now().Add(time.Second)
+
+	sr, ok := s.Report(now)[0].(*rtcp.SenderReport)
+	if !ok {
+		t.Fatal("no SR after sending RTP")
+	}
+
+	if sr.SSRC != 1 || sr.PacketCount != 3 || sr.OctetCount != 3*160 || sr.NTPTime != ntpTime(now) {
+		t.Errorf("SR %+v, want SSRC 1, 3 packets of 160 bytes at %d", sr, ntpTime(now))
+	}
+
+	// the last packet, a second ago, extrapolated to now
+	if want := uint32(1000 + 2*160 + 8000); sr.RTPTime < want || sr.RTPTime > want+80 {
+		t.Errorf("RTP time %d, want %d", sr.RTPTime, want)
+	}
+
+	if len(sr.Reports) != 1 {
+		t.Fatalf("%d reception reports, want 1", len(sr.Reports))
+	}
+
+	report := sr.Reports[0]
+	if report.LastSenderReport != ntpCompact(srAt) {
+		t.Errorf("LSR %#x, want %#x", report.LastSenderReport, ntpCompact(srAt))
+	}
+
+	// DLSR is in 1/65536 seconds
+	if want := uint32(now.Sub(srAt) * 65536 / time.Second); report.Delay != want {
+		t.Errorf("DLSR %d, want %d", report.Delay, want)
+	}
+}
+
+func TestReportRTT(t *testing.T) {
+	s := newStreamStats(1, "cname", nil)
+
+	sentAt := time.Now()
+	arrival := sentAt.Add(300 * time.Millisecond)
+
+	// the peer held our SR for 100 ms, so 200 ms were spent on the wire
+	s.OnRTCPIn([]rtcp.Packet{&rtcp.ReceiverReport{
+		SSRC: 2,
+		Reports: []rtcp.ReceptionReport{{
+			SSRC:             1,
+			FractionLost:     64,
+			TotalLost:        7,
+			LastSenderReport: ntpCompact(sentAt),
+			Delay:            65536 / 10,
+		}},
+	}}, arrival)
+
+	var stats Stats
+	s.Fill(&stats)
+
+	if stats.RTT < 199*time.Millisecond || stats.RTT > 201*time.Millisecond {
+		t.Errorf("RTT %v, want 200ms", stats.RTT)
+	}
+
+	if stats.RemoteFractionLost != 0.25 || stats.RemotePacketsLost != 7 {
+		t.Errorf("remote loss %v/%d, want 0.25/7", stats.RemoteFractionLost, stats.RemotePacketsLost)
+	}
+}
+
+func TestReportBye(t *testing.T) {
+	s := newStreamStats(1, "cname", nil)
+
+	pkts := s.Bye(time.Now(), "hangup")
+	if len(pkts) != 3 {
+		t.Fatalf("%d packets, want RR, SDES and BYE", len(pkts))
+	}
+
+	bye, ok := pkts[2].(*rtcp.Goodbye)
+	if !ok || len(bye.Sources) != 1 || bye.Sources[0] != 1 || bye.Reason != "hangup" {
+		t.Errorf("last packet %+v, want a BYE of SSRC 1 with the reason", pkts[2])
+	}
+
+	if _, err := rtcp.Marshal(pkts); err != nil {
+		t.Errorf("compound packet does not marshal: %v", err)
+	}
+}
+
+func TestNewCNAME(t *testing.T) {
+	a, b := newCNAME(), newCNAME()
+	if len(a) != 16 || a == b {
+		t.Errorf("CNAMEs %q and %q, want two different ones of 16 characters", a, b)
+	}
+}
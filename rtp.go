@@ -1,11 +1,13 @@
 package rtp
 
 import (
+	"context"
 	"fmt"
-	"sync"
 	"time"
 
+	"github.com/livekit/protocol/logger"
 	lksdk "github.com/livekit/server-sdk-go/v2"
+	"github.com/pion/webrtc/v4"
 )
 
 type ConfigLK struct {
@@ -15,31 +17,75 @@ type ConfigLK struct {
 }
 
 type Manager struct {
-	mx sync.Mutex
-
-	session map[string]*session
+	sessions sessionRegistry
 
 	config *ConfigLK
-}
 
-func NewManager(config *ConfigLK) *Manager {
-	return &Manager{
-		session: make(map[string]*session),
+	events  Events
+	log     logger.Logger
+	metrics *metrics
+
+	mixerSampleRate int
+	timeouts        MediaTimeouts
+	reconnectBuffer time.Duration
+}
 
+func NewManager(config *ConfigLK, opts ...ManagerOption) *Manager {
+	r := &Manager{
 		config: config,
+
+		events: NopEvents{},
+		log:    logger.GetLogger(),
+
+		mixerSampleRate: defaultMixerSampleRate,
+		timeouts:        defaultMediaTimeouts(),
+	}
+
+	for _, opt := range opts {
+		opt(r)
 	}
+
+	// checked once all options are applied, so the warning goes to WithLogger
+	switch r.mixerSampleRate {
+	case 8000, 12000, 16000, 24000, 48000:
+	default:
+		r.log.Warnw("unsupported mixer sample rate", nil, "sampleRate", r.mixerSampleRate, "using", defaultMixerSampleRate)
+		r.mixerSampleRate = defaultMixerSampleRate
+	}
+
+	return r
 }
 
-func (r *Manager) ConnectToRoom(roomName, user, identity string) (string, error) {
-	r.mx.Lock()
-	defer r.mx.Unlock()
+// WithMixerSampleRate sets the rate the LiveKit tracks are decoded and mixed
+// at, before being resampled to the codec of the RTP leg. It must be a rate
+// Opus decodes to: 8000, 12000, 16000, 24000 or 48000; NewManager warns and
+// keeps the default otherwise.
+func WithMixerSampleRate(sampleRate int) ManagerOption {
+	return func(r *Manager) {
+		r.mixerSampleRate = sampleRate
+	}
+}
 
+// ConnectToRoom joins roomName as a SIP participant and returns the ID of the
+// new session. The Manager is not locked while connecting, so a slow room
+// does not hold up other sessions; when ctx is done first, the connection is
+// abandoned and closed once it completes.
+func (r *Manager) ConnectToRoom(ctx context.Context, roomName, user, identity string) (string, error) {
 	timestamp := time.Now().UnixNano()
 
 	sID := fmt.Sprintf("%s-%s-%d", roomName, identity, timestamp)
+	log := r.log.WithValues("sessionID", sID, "room", roomName, "identity", identity)
+
+	session := newSession(sID, r.events, log, r.metrics, r.mixerSampleRate, r.timeouts, r.reconnectBuffer, func(reason EndReason) {
+		log.Infow("hanging up", "reason", reason)
+
+		if err := r.disconnect(context.Background(), sID, reason); err != nil {
+			log.Warnw("failed to hang up", err)
+		}
+	})
 
-	if _, ok := r.session[sID]; ok {
-		fmt.Printf("already connected to room: %s (%s:%s)\n", roomName, user, identity)
+	if !r.sessions.add(session) {
+		log.Infow("already connected to room", "user", user)
 
 		return sID, nil
 	}
@@ -47,18 +93,53 @@ func (r *Manager) ConnectToRoom(roomName, user, identity string) (string, error)
 	startedAt := time.Now()
 
 	defer func() {
-		duration := time.Since(startedAt)
-		fmt.Printf("%s: Finished connecting to Livekit room: %s as user: %s (identity: %s) in %v\n",
-			sID, roomName, user, identity, duration,
-		)
+		log.Infow("finished connecting to LiveKit room", "user", user, "duration", time.Since(startedAt))
 	}()
 
-	fmt.Printf("%s: Started ConnectToRoom: %s identity: %s\n", sID, roomName, identity)
+	log.Infow("connecting to LiveKit room", "user", user)
 
 	cb := lksdk.NewRoomCallback()
 	cb.OnTrackSubscribed = r.subscribeTrack(sID, identity)
+	cb.OnDataPacket = r.receiveDataPacket(sID, identity)
+	cb.OnTrackUnsubscribed = func(track *webrtc.TrackRemote, _ *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) {
+		r.events.OnTrackUnsubscribed(sID, rp.Identity(), track.ID())
+	}
+	cb.OnParticipantConnected = func(rp *lksdk.RemoteParticipant) {
+		r.events.OnParticipantJoined(sID, rp.Identity())
+	}
+	cb.OnParticipantDisconnected = func(rp *lksdk.RemoteParticipant) {
+		r.events.OnParticipantLeft(sID, rp.Identity())
+	}
+	cb.OnReconnecting = func() {
+		if session, ok := r.getSession(sID); ok {
+			session.reconnecting()
+		}
+	}
+	cb.OnReconnected = func() {
+		if session, ok := r.getSession(sID); ok && session.reconnected() {
+			go session.restore()
+		}
+	}
+	cb.OnDisconnectedWithReason = func(reason lksdk.DisconnectionReason) {
+		log.Infow("room disconnected", "reason", reason)
+
+		session, ok := r.getSession(sID)
+		if !ok {
+			return
+		}
+
+		endReason := EndReason(reason)
+
+		// the SDK gave up reconnecting
+		if reason == lksdk.Failed {
+			endReason = EndReasonReconnectFailed
+		}
+
+		// leaving the room again, not from within its callback
+		go session.hangup(endReason)
+	}
 
-	room, err := lksdk.ConnectToRoom(r.config.LivekitUrl,
+	room, err := connectRoom(ctx, r.config.LivekitUrl,
 		lksdk.ConnectInfo{
 			APIKey:              r.config.LivekitApiKey,
 			APISecret:           r.config.LivekitApiSecret,
@@ -69,46 +150,109 @@ func (r *Manager) ConnectToRoom(roomName, user, identity string) (string, error)
 		},
 		cb,
 	)
+	r.metrics.observe(opConnect, startedAt, err)
+
 	if err != nil {
-		return "", fmt.Errorf("failed to connect to room: %w", err)
+		r.sessions.remove(session)
+		session.setClosed()
+
+		return "", newRoomConnectError(roomName, err)
+	}
+
+	// DisconnectFromRoom may have closed the session while connecting
+	if err := session.connected(room); err != nil {
+		room.Disconnect()
+
+		return "", err
 	}
 
-	r.session[sID] = newSession(room)
+	r.metrics.sessionStarted(session.stats)
+	r.events.OnSessionStarted(sID)
 
 	return sID, nil
 }
 
-func (r *Manager) DisconnectFromRoom(sID string) error {
+// DisconnectFromRoom tears the session down. It is torn down even if ctx is
+// done first, ctx only bounds the wait for LiveKit to acknowledge the leave.
+func (r *Manager) DisconnectFromRoom(ctx context.Context, sID string) error {
+	return r.disconnect(ctx, sID, EndReasonDisconnected)
+}
+
+func (r *Manager) getSession(sID string) (*session, bool) {
+	return r.sessions.load(sID)
+}
+
+// disconnect tears the session down and reports reason to the peer and to Events.
+func (r *Manager) disconnect(ctx context.Context, sID string, reason EndReason) error {
 	if sID == "" {
 		return nil
 	}
 
-	r.mx.Lock()
-	defer r.mx.Unlock()
-
-	session, ok := r.session[sID]
+	session, ok := r.getSession(sID)
 	if !ok {
 		return fmt.Errorf("session %s: %w", sID, ErrNotFound)
 	}
 
-	defer delete(r.session, sID)
+	prev, err := session.closing(reason)
+	if err != nil {
+		return err
+	}
 
-	if session.streamRTP != nil {
-		session.streamRTP.Close()
+	r.sessions.remove(session)
+
+	// ConnectToRoom finds the session closed and leaves the room itself
+	if prev == StateConnecting {
+		session.setClosed()
+
+		return nil
 	}
 
-	if session.mixer != nil {
-		session.mixer.Stop()
+	defer session.setClosed()
+
+	// reported without holding a lock, the handler may call back into the Manager
+	defer session.end(reason)
+
+	playbacks := session.getPlaybacks()
+
+	for _, p := range playbacks {
+		p.Stop()
+	}
+
+	// the playbacks let go of the mixer and the room, and are reported,
+	// before the session is torn down
+	for _, p := range playbacks {
+		p.waitFinished(ctx)
+	}
+
+	if rec := session.recording.Load(); rec != nil {
+		if err := rec.Stop(); err != nil {
+			session.log.Warnw("failed to stop recording", err)
+		}
+	}
+
+	session.mx.Lock()
+	streamRTP, mix, track := session.streamRTP, session.mixer, session.track
+	session.mx.Unlock()
+
+	if streamRTP != nil {
+		streamRTP.SendBye(string(reason))
+		streamRTP.Close()
 	}
 
-	if session.track != nil {
-		if err := session.track.Close(); err != nil {
-			fmt.Printf("DisconnectFromRoom: failed to close track %s: %v\n", session.track.ID(), err)
+	if mix != nil {
+		mix.Stop()
+	}
+
+	if track != nil {
+		if err := track.Close(); err != nil {
+			session.log.Warnw("failed to close track", err, "trackID", track.ID())
 		}
 	}
 
 	if session.room != nil {
-		session.room.Disconnect()
+		if err := leaveRoom(ctx, session.room); err != nil {
+			return fmt.Errorf("session %s: leaving the room: %w", sID, err)
+		}
 	}
 
 	return nil
@@ -0,0 +1,35 @@
+package rtp
+
+import (
+	"testing"
+
+	"github.com/livekit/protocol/logger"
+)
+
+func TestWithMixerSampleRate(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []ManagerOption
+		want int
+	}{
+		{name: "default", want: defaultMixerSampleRate},
+		{name: "supported", opts: []ManagerOption{WithMixerSampleRate(16000)}, want: 16000},
+		{name: "unsupported", opts: []ManagerOption{WithMixerSampleRate(44100)}, want: defaultMixerSampleRate},
+		{
+			name: "logger after",
+			opts: []ManagerOption{WithMixerSampleRate(44100), WithLogger(logger.GetLogger())},
+			want: defaultMixerSampleRate,
+		},
+		{
+			name: "last one wins",
+			opts: []ManagerOption{WithMixerSampleRate(44100), WithMixerSampleRate(24000)},
+			want: 24000,
+		},
+	}
+
+	for _, tc := range cases {
+		if r := NewManager(&ConfigLK{}, tc.opts...); r.mixerSampleRate != tc.want {
+			t.Errorf("%s: mixer sample rate %d, want %d", tc.name, r.mixerSampleRate, tc.want)
+		}
+	}
+}
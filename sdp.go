@@ -0,0 +1,682 @@
+package rtp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	mathrand "math/rand/v2"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/srtp/v3"
+)
+
+const (
+	defaultPTime = 20
+
+	sdpSessionName = "livekit-rtp"
+)
+
+// Direction is the media direction attribute of an SDP media section.
+type Direction string
+
+const (
+	DirectionSendRecv = Direction("sendrecv")
+	DirectionSendOnly = Direction("sendonly")
+	DirectionRecvOnly = Direction("recvonly")
+	DirectionInactive = Direction("inactive")
+)
+
+// reverse is the direction answering an offer in direction d, RFC 3264 6.1.
+func (d Direction) reverse() Direction {
+	switch d {
+	case DirectionSendOnly:
+		return DirectionRecvOnly
+	case DirectionRecvOnly:
+		return DirectionSendOnly
+	default:
+		return d
+	}
+}
+
+// staticCodecs are the RFC 3551 payload types an offer may list without a=rtpmap.
+var staticCodecs = map[byte]Codec{
+	PayloadTypePCMU: {Name: CodecPCMU, PayloadType: PayloadTypePCMU, ClockRate: 8000},
+	PayloadTypePCMA: {Name: CodecPCMA, PayloadType: PayloadTypePCMA, ClockRate: 8000},
+	PayloadTypeG722: {Name: CodecG722, PayloadType: PayloadTypeG722, ClockRate: 8000},
+
+	PayloadTypeL16Stereo: {Name: CodecL16, PayloadType: PayloadTypeL16Stereo, ClockRate: 44100, Channels: 2},
+	PayloadTypeL16Mono:   {Name: CodecL16, PayloadType: PayloadTypeL16Mono, ClockRate: 44100},
+
+	PayloadTypeCN: {Name: CodecComfortNoise, PayloadType: PayloadTypeCN, ClockRate: 8000},
+}
+
+// DefaultCodecs are the codecs AnswerOffer accepts when none are configured,
+// in order of preference.
+func DefaultCodecs() []Codec {
+	return []Codec{
+		{Name: CodecOpus, PayloadType: 111, ClockRate: 48000},
+		{Name: CodecG722, PayloadType: PayloadTypeG722, ClockRate: 8000},
+		{Name: CodecPCMU, PayloadType: PayloadTypePCMU, ClockRate: 8000},
+		{Name: CodecPCMA, PayloadType: PayloadTypePCMA, ClockRate: 8000},
+		{Name: CodecTelephoneEvent, PayloadType: 101, ClockRate: 48000},
+		{Name: CodecTelephoneEvent, PayloadType: 102, ClockRate: 8000},
+		{Name: CodecComfortNoise, PayloadType: PayloadTypeCN, ClockRate: 8000},
+	}
+}
+
+// AnswerConfig describes our side of an SDP offer/answer exchange.
+type AnswerConfig struct {
+	// LocalIP is the address the RTP sockets are opened on.
+	LocalIP net.IP
+	// AdvertisedIP is the address put in the answer, LocalIP if nil.
+	AdvertisedIP net.IP
+	// PortMin and PortMax bound the local ports, any free port if zero.
+	PortMin, PortMax int
+	// Codecs are the codecs we accept in order of preference, DefaultCodecs if nil.
+	Codecs []Codec
+}
+
+// Answer is the outcome of AnswerOffer. It is bound with
+//
+//	r.BindRTPtoRoom(ctx, a.ConnRTP, a.ConnRTCP, sID, identity, a.Codecs, a.PTime, a.RemoteRTP, a.RemoteRTCP, a.BindOptions()...)
+type Answer struct {
+	// SDP is the answer to send back to the peer.
+	SDP []byte
+
+	// ConnRTCP is nil when RTCP is multiplexed on ConnRTP.
+	ConnRTP, ConnRTCP     net.Conn
+	RemoteRTP, RemoteRTCP *net.UDPAddr
+
+	// Codecs holds the selected audio codec, followed by telephone-event
+	// and CN when both sides support them.
+	Codecs  []Codec
+	PTime   int
+	RTCPMux bool
+	// Direction is our side of the media direction, pass it to SetDirection
+	// once bound.
+	Direction Direction
+
+	srtp      *srtpConfig
+	cryptoTag string
+}
+
+// BindOptions returns the options matching the negotiated RTCP multiplexing and SRTP.
+func (a *Answer) BindOptions() []BindOption {
+	var opts []BindOption
+
+	if a.RTCPMux {
+		opts = append(opts, WithRTCPMux())
+	}
+
+	if a.srtp != nil {
+		opts = append(opts, WithSRTP(a.srtp.local, a.srtp.remote))
+	}
+
+	return opts
+}
+
+// Close releases the sockets of an answer that will not be bound.
+func (a *Answer) Close() error {
+	var errs []error
+
+	if a.ConnRTP != nil {
+		errs = append(errs, a.ConnRTP.Close())
+	}
+
+	if a.ConnRTCP != nil {
+		errs = append(errs, a.ConnRTCP.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// AnswerOffer parses the SDP offer of the peer, selects the first codec of
+// conf.Codecs it offers, opens the local RTP and RTCP sockets and builds the
+// SDP answer. Media sections other than the first usable audio one are
+// rejected with port 0.
+func AnswerOffer(offer []byte, conf AnswerConfig) (*Answer, error) {
+	var sd sdp.SessionDescription
+	if err := sd.Unmarshal(offer); err != nil {
+		return nil, fmt.Errorf("failed to parse SDP offer: %w", err)
+	}
+
+	codecs := conf.Codecs
+	if codecs == nil {
+		codecs = DefaultCodecs()
+	}
+
+	index := slices.IndexFunc(sd.MediaDescriptions, isAudioRTP)
+	if index < 0 {
+		return nil, errors.New("SDP offer has no RTP audio media")
+	}
+
+	md := sd.MediaDescriptions[index]
+
+	a := &Answer{
+		PTime:     defaultPTime,
+		Direction: offerDirection(&sd, md).reverse(),
+	}
+
+	if err := a.negotiate(&sd, md, codecs); err != nil {
+		return nil, err
+	}
+
+	if err := a.listen(conf); err != nil {
+		return nil, err
+	}
+
+	answer, err := a.marshal(&sd, index, conf)
+	if err != nil {
+		a.Close()
+
+		return nil, err
+	}
+
+	a.SDP = answer
+
+	return a, nil
+}
+
+func isAudioRTP(md *sdp.MediaDescription) bool {
+	if md.MediaName.Media != "audio" || md.MediaName.Port.Value == 0 {
+		return false
+	}
+
+	return slices.Contains(md.MediaName.Protos, "RTP") && (slices.Contains(md.MediaName.Protos, "AVP") || slices.Contains(md.MediaName.Protos, "SAVP"))
+}
+
+func isSecure(md *sdp.MediaDescription) bool {
+	return slices.Contains(md.MediaName.Protos, "SAVP")
+}
+
+// negotiate fills a from the offered media section md.
+func (a *Answer) negotiate(sd *sdp.SessionDescription, md *sdp.MediaDescription, codecs []Codec) error {
+	offered := offeredCodecs(md)
+
+	codec, ok := selectCodec(offered, codecs, func(c Codec) bool { return !c.auxiliary() })
+	if !ok {
+		return fmt.Errorf("%w: no common codec in SDP offer: %v", ErrUnsupportedCodec, offered)
+	}
+
+	a.Codecs = []Codec{codec}
+
+	// RFC 4733 events run at the clock of the audio codec, peers offering
+	// Opus with events at 8000 only still get those
+	if event, ok := selectCodec(offered, codecs, func(c Codec) bool { return c.is(CodecTelephoneEvent) && c.ClockRate == codec.ClockRate }); ok {
+		a.Codecs = append(a.Codecs, event)
+	} else if event, ok := selectCodec(offered, codecs, func(c Codec) bool { return c.is(CodecTelephoneEvent) }); ok {
+		a.Codecs = append(a.Codecs, event)
+	}
+
+	if cn, ok := selectCodec(offered, codecs, func(c Codec) bool { return c.is(CodecComfortNoise) && c.ClockRate == 8000 }); ok {
+		a.Codecs = append(a.Codecs, cn)
+	}
+
+	if ptime, ok := md.Attribute("ptime"); ok {
+		if v, err := strconv.Atoi(strings.TrimSpace(ptime)); err == nil && v > 0 {
+			a.PTime = v
+		}
+	}
+
+	ip, err := connectionAddress(sd, md)
+	if err != nil {
+		return err
+	}
+
+	a.RemoteRTP = &net.UDPAddr{IP: ip, Port: md.MediaName.Port.Value}
+
+	_, a.RTCPMux = md.Attribute("rtcp-mux")
+
+	a.RemoteRTCP = &net.UDPAddr{IP: ip, Port: md.MediaName.Port.Value + 1}
+	if a.RTCPMux {
+		a.RemoteRTCP = a.RemoteRTP
+	} else if rtcp, ok := md.Attribute("rtcp"); ok {
+		if a.RemoteRTCP, err = parseRTCPAttribute(rtcp, ip); err != nil {
+			return err
+		}
+	}
+
+	if isSecure(md) {
+		if a.srtp, a.cryptoTag, err = answerCrypto(md); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// offeredCodecs lists the codecs of md in the order of its format list.
+func offeredCodecs(md *sdp.MediaDescription) []Codec {
+	rtpmaps := make(map[byte]Codec)
+	fmtps := make(map[byte]map[string]string)
+
+	for _, attr := range md.Attributes {
+		pt, value, ok := splitFormatAttribute(attr.Value)
+		if !ok {
+			continue
+		}
+
+		switch attr.Key {
+		case "rtpmap":
+			if codec, ok := parseRTPMap(pt, value); ok {
+				rtpmaps[pt] = codec
+			}
+		case "fmtp":
+			fmtps[pt] = parseFmtp(value)
+		}
+	}
+
+	var codecs []Codec
+
+	for _, format := range md.MediaName.Formats {
+		v, err := strconv.ParseUint(format, 10, 8)
+		if err != nil {
+			continue
+		}
+
+		pt := byte(v)
+
+		codec, ok := rtpmaps[pt]
+		if !ok {
+			if codec, ok = staticCodecs[pt]; !ok {
+				continue
+			}
+		}
+
+		codec.Fmtp = fmtps[pt]
+		codecs = append(codecs, codec)
+	}
+
+	return codecs
+}
+
+// selectCodec returns the offered codec matching the first of ours accepted by
+// filter, with the payload type of the offer.
+func selectCodec(offered, ours []Codec, filter func(Codec) bool) (Codec, bool) {
+	for _, our := range ours {
+		if !filter(our) {
+			continue
+		}
+
+		for _, codec := range offered {
+			if codec.is(our.Name) && codec.ClockRate == our.ClockRate {
+				return codec, true
+			}
+		}
+	}
+
+	return Codec{}, false
+}
+
+func splitFormatAttribute(value string) (byte, string, bool) {
+	format, rest, ok := strings.Cut(value, " ")
+	if !ok {
+		return 0, "", false
+	}
+
+	pt, err := strconv.ParseUint(format, 10, 8)
+	if err != nil {
+		return 0, "", false
+	}
+
+	return byte(pt), strings.TrimSpace(rest), true
+}
+
+// parseRTPMap parses "<encoding name>/<clock rate>[/<channels>]".
+func parseRTPMap(pt byte, value string) (Codec, bool) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 {
+		return Codec{}, false
+	}
+
+	clockRate, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Codec{}, false
+	}
+
+	codec := Codec{
+		Name:        parts[0],
+		PayloadType: pt,
+		ClockRate:   clockRate,
+	}
+
+	// opus is always announced with 2 channels, RFC 7587 7, we mix mono
+	if len(parts) > 2 && !codec.is(CodecOpus) {
+		if codec.Channels, err = strconv.Atoi(parts[2]); err != nil {
+			return Codec{}, false
+		}
+	}
+
+	return codec, true
+}
+
+// parseFmtp parses "key=value;key=value". Parameters without a value, such
+// as the "0-16" of telephone-event, are kept as keys with an empty value.
+func parseFmtp(value string) map[string]string {
+	params := make(map[string]string)
+
+	for _, param := range strings.Split(value, ";") {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+
+		k, v, _ := strings.Cut(param, "=")
+		params[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	return params
+}
+
+func formatFmtp(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if params[k] == "" {
+			parts = append(parts, k)
+
+			continue
+		}
+
+		parts = append(parts, k+"="+params[k])
+	}
+
+	return strings.Join(parts, ";")
+}
+
+func connectionAddress(sd *sdp.SessionDescription, md *sdp.MediaDescription) (net.IP, error) {
+	conn := md.ConnectionInformation
+	if conn == nil {
+		conn = sd.ConnectionInformation
+	}
+
+	if conn == nil || conn.Address == nil {
+		return nil, errors.New("SDP offer has no connection address")
+	}
+
+	ip := net.ParseIP(conn.Address.Address)
+	if ip == nil {
+		return nil, fmt.Errorf("SDP offer has an invalid connection address %q", conn.Address.Address)
+	}
+
+	return ip, nil
+}
+
+// parseRTCPAttribute parses "<port> [IN IP4 <address>]", RFC 3605.
+func parseRTCPAttribute(value string, ip net.IP) (*net.UDPAddr, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("invalid a=rtcp:%s", value)
+	}
+
+	port, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid a=rtcp:%s: %w", value, err)
+	}
+
+	if len(fields) == 4 {
+		if ip = net.ParseIP(fields[3]); ip == nil {
+			return nil, fmt.Errorf("invalid a=rtcp:%s", value)
+		}
+	}
+
+	return &net.UDPAddr{IP: ip, Port: port}, nil
+}
+
+func offerDirection(sd *sdp.SessionDescription, md *sdp.MediaDescription) Direction {
+	for _, dir := range []Direction{DirectionSendRecv, DirectionSendOnly, DirectionRecvOnly, DirectionInactive} {
+		if _, ok := md.Attribute(string(dir)); ok {
+			return dir
+		}
+	}
+
+	for _, dir := range []Direction{DirectionSendRecv, DirectionSendOnly, DirectionRecvOnly, DirectionInactive} {
+		if _, ok := sd.Attribute(string(dir)); ok {
+			return dir
+		}
+	}
+
+	return DirectionSendRecv
+}
+
+// answerCrypto takes the first a=crypto of md with a suite we support,
+// RFC 4568, and generates our own keys for it. It returns the keys and the
+// tag of the accepted attribute.
+func answerCrypto(md *sdp.MediaDescription) (*srtpConfig, string, error) {
+	for _, attr := range md.Attributes {
+		if attr.Key != "crypto" {
+			continue
+		}
+
+		fields := strings.Fields(attr.Value)
+		if len(fields) < 3 || !strings.HasPrefix(fields[2], "inline:") {
+			continue
+		}
+
+		remote := SRTPKeys{Suite: SRTPCryptoSuite(fields[1])}
+
+		profile, err := remote.profile()
+		if err != nil {
+			continue
+		}
+
+		keyLen, saltLen, err := profileKeyLen(profile)
+		if err != nil {
+			continue
+		}
+
+		// inline:<key||salt>[|lifetime][|MKI:length]
+		inline, _, _ := strings.Cut(strings.TrimPrefix(fields[2], "inline:"), "|")
+
+		material, err := base64.StdEncoding.DecodeString(inline)
+		if err != nil {
+			if material, err = base64.RawStdEncoding.DecodeString(inline); err != nil {
+				continue
+			}
+		}
+
+		if len(material) != keyLen+saltLen {
+			continue
+		}
+
+		remote.MasterKey, remote.MasterSalt = material[:keyLen], material[keyLen:]
+
+		local := SRTPKeys{
+			Suite:      remote.Suite,
+			MasterKey:  make([]byte, keyLen),
+			MasterSalt: make([]byte, saltLen),
+		}
+
+		if _, err := rand.Read(local.MasterKey); err != nil {
+			return nil, "", fmt.Errorf("failed to generate SRTP key: %w", err)
+		}
+
+		if _, err := rand.Read(local.MasterSalt); err != nil {
+			return nil, "", fmt.Errorf("failed to generate SRTP salt: %w", err)
+		}
+
+		return &srtpConfig{local: local, remote: remote}, fields[0], nil
+	}
+
+	return nil, "", errors.New("SDP offer has no supported a=crypto")
+}
+
+func profileKeyLen(profile srtp.ProtectionProfile) (int, int, error) {
+	keyLen, err := profile.KeyLen()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	saltLen, err := profile.SaltLen()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return keyLen, saltLen, nil
+}
+
+// listen opens the local sockets, RTCP on a separate one unless multiplexed.
+func (a *Answer) listen(conf AnswerConfig) error {
+	connRTP, err := listenUDP(conf.LocalIP, conf.PortMin, conf.PortMax)
+	if err != nil {
+		return fmt.Errorf("failed to open RTP socket: %w", err)
+	}
+
+	a.ConnRTP = connRTP
+
+	if a.RTCPMux {
+		return nil
+	}
+
+	connRTCP, err := listenUDP(conf.LocalIP, conf.PortMin, conf.PortMax)
+	if err != nil {
+		a.Close()
+
+		return fmt.Errorf("failed to open RTCP socket: %w", err)
+	}
+
+	a.ConnRTCP = connRTCP
+
+	return nil
+}
+
+func listenUDP(ip net.IP, portMin, portMax int) (*net.UDPConn, error) {
+	if portMin == 0 || portMax < portMin {
+		return net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	}
+
+	n := portMax - portMin + 1
+	start := mathrand.IntN(n)
+
+	var err error
+
+	for i := range n {
+		var conn *net.UDPConn
+		if conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: portMin + (start+i)%n}); err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no free port in %d-%d: %w", portMin, portMax, err)
+}
+
+// marshal builds the answer, accepting the media section at index and rejecting the others.
+func (a *Answer) marshal(offer *sdp.SessionDescription, index int, conf AnswerConfig) ([]byte, error) {
+	ip := conf.AdvertisedIP
+	if ip == nil {
+		ip = conf.LocalIP
+	}
+
+	if ip == nil || ip.IsUnspecified() {
+		ip = a.ConnRTP.LocalAddr().(*net.UDPAddr).IP
+	}
+
+	addrType := "IP4"
+	if ip.To4() == nil {
+		addrType = "IP6"
+	}
+
+	sessionID := mathrand.Uint64() >> 1
+
+	answer := &sdp.SessionDescription{
+		Origin: sdp.Origin{
+			Username:       "-",
+			SessionID:      sessionID,
+			SessionVersion: sessionID,
+			NetworkType:    "IN",
+			AddressType:    addrType,
+			UnicastAddress: ip.String(),
+		},
+		SessionName: sdpSessionName,
+		ConnectionInformation: &sdp.ConnectionInformation{
+			NetworkType: "IN",
+			AddressType: addrType,
+			Address:     &sdp.Address{Address: ip.String()},
+		},
+		TimeDescriptions: []sdp.TimeDescription{{}},
+	}
+
+	for i, md := range offer.MediaDescriptions {
+		if i != index {
+			answer.MediaDescriptions = append(answer.MediaDescriptions, &sdp.MediaDescription{
+				MediaName: sdp.MediaName{
+					Media:   md.MediaName.Media,
+					Port:    sdp.RangedPort{Value: 0},
+					Protos:  md.MediaName.Protos,
+					Formats: md.MediaName.Formats,
+				},
+			})
+
+			continue
+		}
+
+		answer.MediaDescriptions = append(answer.MediaDescriptions, a.mediaDescription(md))
+	}
+
+	return answer.Marshal()
+}
+
+func (a *Answer) mediaDescription(offer *sdp.MediaDescription) *sdp.MediaDescription {
+	md := &sdp.MediaDescription{
+		MediaName: sdp.MediaName{
+			Media:  "audio",
+			Port:   sdp.RangedPort{Value: a.ConnRTP.LocalAddr().(*net.UDPAddr).Port},
+			Protos: offer.MediaName.Protos,
+		},
+	}
+
+	for _, codec := range a.Codecs {
+		pt := strconv.Itoa(int(codec.PayloadType))
+		md.MediaName.Formats = append(md.MediaName.Formats, pt)
+
+		rtpmap := fmt.Sprintf("%s %s/%d", pt, codec.Name, codec.ClockRate)
+		if codec.is(CodecOpus) {
+			rtpmap += "/2"
+		} else if codec.Channels > 1 {
+			rtpmap += "/" + strconv.Itoa(codec.Channels)
+		}
+
+		md.WithValueAttribute("rtpmap", rtpmap)
+
+		fmtp := codec.Fmtp
+		if codec.is(CodecTelephoneEvent) && len(fmtp) == 0 {
+			// the DTMF events, RFC 4733 3.2
+			fmtp = map[string]string{"0-16": ""}
+		}
+
+		if len(fmtp) > 0 {
+			md.WithValueAttribute("fmtp", pt+" "+formatFmtp(fmtp))
+		}
+	}
+
+	md.WithValueAttribute("ptime", strconv.Itoa(a.PTime))
+
+	if a.RTCPMux {
+		md.WithPropertyAttribute("rtcp-mux")
+	} else {
+		md.WithValueAttribute("rtcp", strconv.Itoa(a.ConnRTCP.LocalAddr().(*net.UDPAddr).Port))
+	}
+
+	if a.srtp != nil {
+		md.WithValueAttribute("crypto", fmt.Sprintf("%s %s inline:%s",
+			a.cryptoTag, a.srtp.local.Suite,
+			base64.StdEncoding.EncodeToString(append(slices.Clone(a.srtp.local.MasterKey), a.srtp.local.MasterSalt...)),
+		))
+	}
+
+	md.WithPropertyAttribute(string(a.Direction))
+
+	return md
+}
@@ -0,0 +1,258 @@
+package rtp
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sdpOffer builds an offer for one audio section with the given
+// transport, format list and attributes.
+func sdpOffer(proto, formats string, attrs ...string) []byte {
+	lines := []string{
+		"v=0",
+		"o=- 1 1 IN IP4 192.0.2.1",
+		"s=-",
+		"c=IN IP4 192.0.2.1",
+		"t=0 0",
+		"m=audio 40000 " + proto + " " + formats,
+	}
+
+	for _, attr := range attrs {
+		lines = append(lines, "a="+attr)
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func answerOffer(t *testing.T, offer []byte) *Answer {
+	t.Helper()
+
+	a, err := AnswerOffer(offer, AnswerConfig{LocalIP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = a.Close() })
+
+	return a
+}
+
+func TestAnswerOfferCodecs(t *testing.T) {
+	cases := []struct {
+		name    string
+		formats string
+		attrs   []string
+		want    []string
+		answer  []string
+	}{
+		{
+			name:    "static PCMU",
+			formats: "0",
+			want:    []string{"PCMU/8000/0"},
+			answer:  []string{"a=rtpmap:0 PCMU/8000"},
+		},
+		{
+			name:    "our preference wins",
+			formats: "0 8 111",
+			attrs:   []string{"rtpmap:111 opus/48000/2"},
+			want:    []string{"opus/48000/111"},
+			answer:  []string{"m=audio ", " RTP/AVP 111", "a=rtpmap:111 opus/48000/2"},
+		},
+		{
+			name:    "events at the clock of Opus",
+			formats: "111 101 110",
+			attrs:   []string{"rtpmap:111 opus/48000/2", "rtpmap:101 telephone-event/8000", "rtpmap:110 telephone-event/48000"},
+			want:    []string{"opus/48000/111", "telephone-event/48000/110"},
+			answer:  []string{"a=rtpmap:110 telephone-event/48000", "a=fmtp:110 0-16"},
+		},
+		{
+			name:    "events at the clock of PCMU",
+			formats: "0 110 101",
+			attrs:   []string{"rtpmap:110 telephone-event/48000", "rtpmap:101 telephone-event/8000", "fmtp:101 0-15"},
+			want:    []string{"PCMU/8000/0", "telephone-event/8000/101"},
+			answer:  []string{"a=rtpmap:101 telephone-event/8000", "a=fmtp:101 0-15"},
+		},
+		{
+			name:    "events at 8000 next to Opus",
+			formats: "111 101",
+			attrs:   []string{"rtpmap:111 opus/48000/2", "rtpmap:101 telephone-event/8000"},
+			want:    []string{"opus/48000/111", "telephone-event/8000/101"},
+		},
+		{
+			name:    "comfort noise",
+			formats: "8 13",
+			want:    []string{"PCMA/8000/8", "CN/8000/13"},
+			answer:  []string{"a=rtpmap:13 CN/8000"},
+		},
+		{
+			name:    "dynamic payload type",
+			formats: "96",
+			attrs:   []string{"rtpmap:96 G722/8000"},
+			want:    []string{"G722/8000/96"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := answerOffer(t, sdpOffer("RTP/AVP", tc.formats, tc.attrs...))
+
+			var got []string
+			for _, c := range a.Codecs {
+				got = append(got, fmt.Sprintf("%s/%d/%d", c.Name, c.ClockRate, c.PayloadType))
+			}
+
+			if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+				t.Errorf("codecs = %v, want %v", got, tc.want)
+			}
+
+			for _, line := range tc.answer {
+				if !strings.Contains(string(a.SDP), line) {
+					t.Errorf("answer has no %q:\n%s", line, a.SDP)
+				}
+			}
+		})
+	}
+}
+
+func TestAnswerOfferNoCommonCodec(t *testing.T) {
+	_, err := AnswerOffer(sdpOffer("RTP/AVP", "18", "rtpmap:18 G729/8000"), AnswerConfig{LocalIP: net.IPv4(127, 0, 0, 1)})
+	if !errors.Is(err, ErrUnsupportedCodec) {
+		t.Errorf("err = %v, want ErrUnsupportedCodec", err)
+	}
+}
+
+func TestAnswerOfferRTCP(t *testing.T) {
+	cases := []struct {
+		name       string
+		attrs      []string
+		mux        bool
+		remoteRTCP string
+		answer     string
+	}{
+		{name: "next port", remoteRTCP: "192.0.2.1:40001", answer: "a=rtcp:"},
+		{name: "rtcp attribute", attrs: []string{"rtcp:40010"}, remoteRTCP: "192.0.2.1:40010", answer: "a=rtcp:"},
+		{name: "rtcp attribute with address", attrs: []string{"rtcp:40010 IN IP4 192.0.2.2"}, remoteRTCP: "192.0.2.2:40010", answer: "a=rtcp:"},
+		{name: "rtcp-mux", attrs: []string{"rtcp-mux"}, mux: true, remoteRTCP: "192.0.2.1:40000", answer: "a=rtcp-mux"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := answerOffer(t, sdpOffer("RTP/AVP", "0", tc.attrs...))
+
+			if a.RTCPMux != tc.mux {
+				t.Errorf("RTCPMux = %v, want %v", a.RTCPMux, tc.mux)
+			}
+
+			if got := a.RemoteRTCP.String(); got != tc.remoteRTCP {
+				t.Errorf("RemoteRTCP = %s, want %s", got, tc.remoteRTCP)
+			}
+
+			if (a.ConnRTCP == nil) != tc.mux {
+				t.Errorf("ConnRTCP = %v with rtcp-mux %v", a.ConnRTCP, tc.mux)
+			}
+
+			if got := len(a.BindOptions()); tc.mux && got != 1 || !tc.mux && got != 0 {
+				t.Errorf("%d bind options with rtcp-mux %v", got, tc.mux)
+			}
+
+			if !strings.Contains(string(a.SDP), tc.answer) {
+				t.Errorf("answer has no %q:\n%s", tc.answer, a.SDP)
+			}
+		})
+	}
+}
+
+func TestAnswerOfferCrypto(t *testing.T) {
+	key := func(n int) string {
+		return base64.StdEncoding.EncodeToString(make([]byte, n))
+	}
+
+	cases := []struct {
+		name    string
+		attrs   []string
+		suite   string
+		tag     string
+		keySalt int
+		wantErr bool
+	}{
+		{
+			name:    "AES_CM_128_HMAC_SHA1_80",
+			attrs:   []string{"crypto:1 AES_CM_128_HMAC_SHA1_80 inline:" + key(30)},
+			suite:   "AES_CM_128_HMAC_SHA1_80",
+			tag:     "1",
+			keySalt: 30,
+		},
+		{
+			name:    "lifetime and MKI",
+			attrs:   []string{"crypto:3 AES_CM_128_HMAC_SHA1_32 inline:" + key(30) + "|2^31|1:1"},
+			suite:   "AES_CM_128_HMAC_SHA1_32",
+			tag:     "3",
+			keySalt: 30,
+		},
+		{
+			name: "first supported suite",
+			attrs: []string{
+				"crypto:1 AES_256_CM_HMAC_SHA1_80 inline:" + key(46),
+				"crypto:2 AES_CM_128_HMAC_SHA1_80 inline:" + key(30),
+			},
+			suite:   "AES_CM_128_HMAC_SHA1_80",
+			tag:     "2",
+			keySalt: 30,
+		},
+		{
+			name:    "wrong key length",
+			attrs:   []string{"crypto:1 AES_CM_128_HMAC_SHA1_80 inline:" + key(16)},
+			wantErr: true,
+		},
+		{
+			name:    "no crypto",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := AnswerOffer(sdpOffer("RTP/SAVP", "0", tc.attrs...), AnswerConfig{LocalIP: net.IPv4(127, 0, 0, 1)})
+			if tc.wantErr {
+				if err == nil {
+					_ = a.Close()
+					t.Fatal("no error for an offer without usable crypto")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer a.Close()
+
+			if a.srtp == nil {
+				t.Fatal("SRTP not negotiated")
+			}
+
+			local := a.srtp.local
+			if string(local.Suite) != tc.suite || string(a.srtp.remote.Suite) != tc.suite {
+				t.Errorf("suites = %s/%s, want %s", local.Suite, a.srtp.remote.Suite, tc.suite)
+			}
+
+			if got := len(local.MasterKey) + len(local.MasterSalt); got != tc.keySalt {
+				t.Errorf("local key and salt of %d bytes, want %d", got, tc.keySalt)
+			}
+
+			inline := base64.StdEncoding.EncodeToString(append(slices.Clone(local.MasterKey), local.MasterSalt...))
+			if want := "a=crypto:" + tc.tag + " " + tc.suite + " inline:" + inline; !strings.Contains(string(a.SDP), want) {
+				t.Errorf("answer has no %q:\n%s", want, a.SDP)
+			}
+
+			if !strings.Contains(string(a.SDP), " RTP/SAVP ") {
+				t.Errorf("answer is not RTP/SAVP:\n%s", a.SDP)
+			}
+		})
+	}
+}
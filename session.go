@@ -1,49 +1,229 @@
 package rtp
 
 import (
+	"math/rand/v2"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/livekit/media-sdk"
 	"github.com/livekit/media-sdk/mixer"
+	"github.com/livekit/media-sdk/rtp"
+	"github.com/livekit/protocol/logger"
 	lksdk "github.com/livekit/server-sdk-go/v2"
 )
 
 type session struct {
+	mx sync.Mutex
+	// ops serializes BindRTPtoRoom, RebindRTP and ACK of the session,
+	// without holding up the Manager or other sessions.
+	ops sync.Mutex
+
+	id        string
+	state     SessionState
+	endReason EndReason
+	events    Events
+	log       logger.Logger
+	metrics   *metrics
+	ended     sync.Once
+
 	room        *lksdk.Room
 	stats       *mixer.Stats
+	streamStats *streamStats
 	mixer       *mixer.Mixer
 	track       *lksdk.LocalTrack
 	rtpProvider *rtpSampleProvider
 	streamRTP   *streamRTP
 	connRTCP    net.Conn
+	mediaWriter *mediaWriter[media.Writer[media.PCM16Sample]]
+
+	// relayRTP and seqWriter outlive a single binding, so SSRC, sequence
+	// numbers and timestamps stay continuous for the RTP peer on re-INVITE.
+	relayRTP  *relayRTP
+	seqWriter *rtp.SeqWriter
+
+	inputs    map[*subscribedTrack]struct{}
+	playbacks map[*Playback]struct{}
+
+	dtmfMx     sync.Mutex
+	dtmfStream *eventStream
+
+	writing     bool
+	publication *lksdk.LocalTrackPublication
+
+	direction Direction
+	holdMusic *holdMusic
 
-	channels int
+	// recording is shared with the provider and the media writer of each
+	// binding, recordMx serializes StartRecording
+	recording atomic.Pointer[Recording]
+	recordMx  sync.Mutex
+
+	channels        int
+	mixerSampleRate int
+	timeouts        MediaTimeouts
+
+	// roomReconnecting is set while the room reconnects, the RTP from the
+	// peer is held back up to reconnectBuffer then.
+	roomReconnecting bool
+	reconnectBuffer  time.Duration
+
+	// hangup disconnects the session from its own goroutines, e.g. on media timeout.
+	hangup func(reason EndReason)
 }
 
-func newSession(room *lksdk.Room) *session {
+func newSession(
+	id string,
+	events Events,
+	log logger.Logger,
+	metrics *metrics,
+	mixerSampleRate int,
+	timeouts MediaTimeouts,
+	reconnectBuffer time.Duration,
+	hangup func(EndReason),
+) *session {
+	ssrc := rand.Uint32()
+	relay := newRelayRTP(ssrc)
+
 	return &session{
-		room:     room,
-		stats:    &mixer.Stats{},
-		channels: 1,
+		id:          id,
+		events:      events,
+		log:         log,
+		metrics:     metrics,
+		stats:       &mixer.Stats{},
+		streamStats: newStreamStats(ssrc, newCNAME(), metrics),
+		relayRTP:    relay,
+		seqWriter:   rtp.NewSeqWriter(relay),
+		inputs:      make(map[*subscribedTrack]struct{}),
+		playbacks:   make(map[*Playback]struct{}),
+		channels:    1,
+		direction:   DirectionSendRecv,
+
+		mixerSampleRate: mixerSampleRate,
+		timeouts:        timeouts,
+		reconnectBuffer: reconnectBuffer,
+		hangup:          hangup,
 	}
 }
 
+// SetParams installs a binding made by op, taking a connected session to
+// StateBound. It fails if the session was closed in the meantime.
 func (s *session) SetParams(
+	op string,
 	channels int,
 	mixer *mixer.Mixer,
 	track *lksdk.LocalTrack,
 	rtpProvider *rtpSampleProvider,
 	streamRTP *streamRTP,
 	connRTCP net.Conn,
-) {
+	mediaWriter *mediaWriter[media.Writer[media.PCM16Sample]],
+	dtmfStream *eventStream,
+) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	next := max(s.state, StateBound)
+	if err := s.transitionLocked(op, next, StateConnected, StateBound, StateActive); err != nil {
+		return err
+	}
+
 	s.channels,
 		s.mixer,
 		s.track,
 		s.rtpProvider,
 		s.streamRTP,
-		s.connRTCP = channels,
+		s.connRTCP,
+		s.mediaWriter,
+		s.dtmfStream = channels,
 		mixer,
 		track,
 		rtpProvider,
 		streamRTP,
-		connRTCP
+		connRTCP,
+		mediaWriter,
+		dtmfStream
+
+	if s.roomReconnecting {
+		streamRTP.pause(s.reconnectBuffer)
+	}
+
+	s.relayRTP.SetStream(streamRTP)
+	s.applyDirectionLocked()
+
+	return nil
+}
+
+// end reports the end of the session once, whichever side ends it first.
+func (s *session) end(reason EndReason) {
+	s.ended.Do(func() {
+		s.metrics.sessionEnded(s.stats, reason)
+		s.events.OnSessionEnded(s.id, reason)
+	})
+}
+
+func (s *session) getStreamRTP() *streamRTP {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.streamRTP
+}
+
+func (s *session) getMixer() (*mixer.Mixer, int) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.mixer, s.channels
+}
+
+func (s *session) addInput(input *subscribedTrack) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.inputs[input] = struct{}{}
+}
+
+func (s *session) removeInput(input *subscribedTrack) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	delete(s.inputs, input)
+}
+
+func (s *session) getInputs() []*subscribedTrack {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	inputs := make([]*subscribedTrack, 0, len(s.inputs))
+	for input := range s.inputs {
+		inputs = append(inputs, input)
+	}
+
+	return inputs
+}
+
+func (s *session) addPlayback(p *Playback) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.playbacks[p] = struct{}{}
+}
+
+func (s *session) removePlayback(p *Playback) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	delete(s.playbacks, p)
+}
+
+func (s *session) getPlaybacks() []*Playback {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	playbacks := make([]*Playback, 0, len(s.playbacks))
+	for p := range s.playbacks {
+		playbacks = append(playbacks, p)
+	}
+
+	return playbacks
 }
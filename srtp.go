@@ -0,0 +1,150 @@
+package rtp
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/livekit/media-sdk/rtp"
+	"github.com/pion/srtp/v3"
+)
+
+const (
+	srtpReplayWindow = 64
+)
+
+// SRTPCryptoSuite is an SDES crypto suite from an a=crypto attribute.
+type SRTPCryptoSuite string
+
+const (
+	SRTPAES128CMHMACSHA180 = SRTPCryptoSuite("AES_CM_128_HMAC_SHA1_80")
+	SRTPAES128CMHMACSHA132 = SRTPCryptoSuite("AES_CM_128_HMAC_SHA1_32")
+	SRTPAEADAES128GCM      = SRTPCryptoSuite("AEAD_AES_128_GCM")
+)
+
+// SRTPKeys are the master key and salt of one direction of the RTP leg.
+type SRTPKeys struct {
+	Suite      SRTPCryptoSuite
+	MasterKey  []byte
+	MasterSalt []byte
+}
+
+func (k SRTPKeys) equal(other SRTPKeys) bool {
+	return k.Suite == other.Suite &&
+		bytes.Equal(k.MasterKey, other.MasterKey) &&
+		bytes.Equal(k.MasterSalt, other.MasterSalt)
+}
+
+func (k SRTPKeys) profile() (srtp.ProtectionProfile, error) {
+	switch k.Suite {
+	case SRTPAES128CMHMACSHA180:
+		return srtp.ProtectionProfileAes128CmHmacSha1_80, nil
+	case SRTPAES128CMHMACSHA132:
+		return srtp.ProtectionProfileAes128CmHmacSha1_32, nil
+	case SRTPAEADAES128GCM:
+		return srtp.ProtectionProfileAeadAes128Gcm, nil
+	default:
+		return 0, fmt.Errorf("unsupported SRTP crypto suite %q", k.Suite)
+	}
+}
+
+// WithSRTP protects the RTP leg with SRTP. local are the keys we announced
+// and encrypt with, remote the keys announced by the peer.
+func WithSRTP(local, remote SRTPKeys) BindOption {
+	return func(c *bindConfig) {
+		c.srtp = &srtpConfig{
+			local:  local,
+			remote: remote,
+		}
+	}
+}
+
+type srtpConfig struct {
+	local, remote SRTPKeys
+}
+
+// srtpContexts encrypts what streamRTP sends and decrypts what it reads.
+// pion/srtp contexts are not safe for concurrent use, hence the locks.
+type srtpContexts struct {
+	conf srtpConfig
+
+	encryptMx sync.Mutex
+	encrypt   *srtp.Context
+
+	decryptMx sync.Mutex
+	decrypt   *srtp.Context
+}
+
+func newSRTPContexts(conf *srtpConfig) (*srtpContexts, error) {
+	localProfile, err := conf.local.profile()
+	if err != nil {
+		return nil, err
+	}
+
+	remoteProfile, err := conf.remote.profile()
+	if err != nil {
+		return nil, err
+	}
+
+	encrypt, err := srtp.CreateContext(conf.local.MasterKey, conf.local.MasterSalt, localProfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SRTP encrypt context: %w", err)
+	}
+
+	decrypt, err := srtp.CreateContext(
+		conf.remote.MasterKey, conf.remote.MasterSalt, remoteProfile,
+		srtp.SRTPReplayProtection(srtpReplayWindow),
+		srtp.SRTCPReplayProtection(srtpReplayWindow),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SRTP decrypt context: %w", err)
+	}
+
+	return &srtpContexts{
+		conf:    *conf,
+		encrypt: encrypt,
+		decrypt: decrypt,
+	}, nil
+}
+
+// rebindSRTPContexts keeps the contexts of prev when the keys did not change,
+// so rollover counters stay in sync with the peer, and rolls the keys otherwise.
+func rebindSRTPContexts(prev *srtpContexts, conf *srtpConfig) (*srtpContexts, error) {
+	if conf == nil {
+		return nil, nil
+	}
+
+	if prev != nil && prev.conf.local.equal(conf.local) && prev.conf.remote.equal(conf.remote) {
+		return prev, nil
+	}
+
+	return newSRTPContexts(conf)
+}
+
+func (s *srtpContexts) EncryptRTP(data []byte, h *rtp.Header) ([]byte, error) {
+	s.encryptMx.Lock()
+	defer s.encryptMx.Unlock()
+
+	return s.encrypt.EncryptRTP(nil, data, h)
+}
+
+func (s *srtpContexts) DecryptRTP(data []byte) ([]byte, error) {
+	s.decryptMx.Lock()
+	defer s.decryptMx.Unlock()
+
+	return s.decrypt.DecryptRTP(nil, data, nil)
+}
+
+func (s *srtpContexts) EncryptRTCP(data []byte) ([]byte, error) {
+	s.encryptMx.Lock()
+	defer s.encryptMx.Unlock()
+
+	return s.encrypt.EncryptRTCP(nil, data, nil)
+}
+
+func (s *srtpContexts) DecryptRTCP(data []byte) ([]byte, error) {
+	s.decryptMx.Lock()
+	defer s.decryptMx.Unlock()
+
+	return s.decrypt.DecryptRTCP(nil, data, nil)
+}
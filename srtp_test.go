@@ -0,0 +1,188 @@
+package rtp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/livekit/media-sdk/rtp"
+	"github.com/pion/rtcp"
+)
+
+func testSRTPKeys(suite SRTPCryptoSuite, seed byte) SRTPKeys {
+	saltLen := 14
+	if suite == SRTPAEADAES128GCM {
+		saltLen = 12
+	}
+
+	return SRTPKeys{
+		Suite:      suite,
+		MasterKey:  bytes.Repeat([]byte{seed}, 16),
+		MasterSalt: bytes.Repeat([]byte{seed + 1}, saltLen),
+	}
+}
+
+// newSRTPPair returns the contexts of both ends of an SRTP leg.
+func newSRTPPair(t *testing.T, suite SRTPCryptoSuite) (a, b *srtpContexts) {
+	t.Helper()
+
+	ka, kb := testSRTPKeys(suite, 1), testSRTPKeys(suite, 3)
+
+	a, err := newSRTPContexts(&srtpConfig{local: ka, remote: kb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = newSRTPContexts(&srtpConfig{local: kb, remote: ka})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a, b
+}
+
+func marshalTestRTP(t *testing.T, seq uint16) ([]byte, *rtp.Header) {
+	t.Helper()
+
+	pkt := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 0, SequenceNumber: seq, Timestamp: uint32(seq) * 160, SSRC: 1},
+		Payload: bytes.Repeat([]byte{byte(seq)}, 160),
+	}
+
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data, &pkt.Header
+}
+
+func TestSRTPRoundTrip(t *testing.T) {
+	for _, suite := range []SRTPCryptoSuite{SRTPAES128CMHMACSHA180, SRTPAES128CMHMACSHA132, SRTPAEADAES128GCM} {
+		t.Run(string(suite), func(t *testing.T) {
+			a, b := newSRTPPair(t, suite)
+
+			for seq := uint16(1); seq <= 3; seq++ {
+				data, h := marshalTestRTP(t, seq)
+
+				enc, err := a.EncryptRTP(data, h)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if bytes.Equal(enc[12:12+160], data[12:]) {
+					t.Fatal("payload sent in the clear")
+				}
+
+				dec, err := b.DecryptRTP(enc)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(dec, data) {
+					t.Fatalf("packet %d does not survive the round trip", seq)
+				}
+			}
+
+			data, err := rtcp.Marshal([]rtcp.Packet{&rtcp.ReceiverReport{SSRC: 1}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			enc, err := b.EncryptRTCP(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dec, err := a.DecryptRTCP(enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(dec, data) {
+				t.Fatal("RTCP does not survive the round trip")
+			}
+		})
+	}
+}
+
+func TestSRTPRejectsUnauthenticated(t *testing.T) {
+	a, b := newSRTPPair(t, SRTPAES128CMHMACSHA180)
+
+	data, h := marshalTestRTP(t, 1)
+
+	enc, err := a.EncryptRTP(data, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := bytes.Clone(enc)
+	tampered[20] ^= 0xff
+
+	if _, err := b.DecryptRTP(tampered); err == nil {
+		t.Error("tampered packet accepted")
+	}
+
+	plain, _ := marshalTestRTP(t, 2)
+	if _, err := b.DecryptRTP(plain); err == nil {
+		t.Error("plain RTP accepted")
+	}
+
+	if _, err := b.DecryptRTP(enc); err != nil {
+		t.Fatalf("authentic packet rejected: %v", err)
+	}
+
+	if _, err := b.DecryptRTP(enc); err == nil {
+		t.Error("replayed packet accepted")
+	}
+
+	rtcpData, err := rtcp.Marshal([]rtcp.Packet{&rtcp.ReceiverReport{SSRC: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.DecryptRTCP(rtcpData); err == nil {
+		t.Error("plain RTCP accepted")
+	}
+}
+
+func TestSRTPUnsupportedSuite(t *testing.T) {
+	keys := testSRTPKeys("F8_128_HMAC_SHA1_80", 1)
+
+	if _, err := newSRTPContexts(&srtpConfig{local: keys, remote: keys}); err == nil {
+		t.Error("unsupported suite accepted")
+	}
+}
+
+func TestRebindSRTPContexts(t *testing.T) {
+	conf := &srtpConfig{
+		local:  testSRTPKeys(SRTPAES128CMHMACSHA180, 1),
+		remote: testSRTPKeys(SRTPAES128CMHMACSHA180, 3),
+	}
+
+	prev, err := newSRTPContexts(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same := &srtpConfig{
+		local:  testSRTPKeys(SRTPAES128CMHMACSHA180, 1),
+		remote: testSRTPKeys(SRTPAES128CMHMACSHA180, 3),
+	}
+
+	rolled := &srtpConfig{
+		local:  testSRTPKeys(SRTPAES128CMHMACSHA180, 1),
+		remote: testSRTPKeys(SRTPAES128CMHMACSHA180, 5),
+	}
+
+	if s, err := rebindSRTPContexts(prev, same); err != nil || s != prev {
+		t.Errorf("same keys: %p, %v, want the previous contexts", s, err)
+	}
+
+	if s, err := rebindSRTPContexts(prev, rolled); err != nil || s == nil || s == prev {
+		t.Errorf("new remote keys: %p, %v, want new contexts", s, err)
+	}
+
+	if s, err := rebindSRTPContexts(prev, nil); err != nil || s != nil {
+		t.Errorf("no SRTP: %p, %v, want none", s, err)
+	}
+}
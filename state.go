@@ -0,0 +1,193 @@
+package rtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	lksdk "github.com/livekit/server-sdk-go/v2"
+)
+
+// SessionState is where a session is in its lifecycle:
+//
+//	Connecting -> Connected -> Bound -> Active
+//
+// ConnectToRoom, BindRTPtoRoom and ACK each take it one step, RebindRTP
+// keeps it Bound or Active, and DisconnectFromRoom or a timeout takes it
+// from any of them to Closing, then Closed.
+type SessionState int32
+
+const (
+	StateConnecting SessionState = iota
+	StateConnected
+	StateBound
+	StateActive
+	StateClosing
+	StateClosed
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateBound:
+		return "bound"
+	case StateActive:
+		return "active"
+	case StateClosing:
+		return "closing"
+	case StateClosed:
+		return "closed"
+	}
+
+	return fmt.Sprintf("SessionState(%d)", int32(s))
+}
+
+// Operations on a session, as named by StateError and the metrics.
+const (
+	opConnect    = "connect"
+	opBind       = "bind"
+	opRebind     = "rebind"
+	opACK        = "ack"
+	opDisconnect = "disconnect"
+	opDirection  = "set direction"
+	opPlay       = "play"
+	opRecord     = "record"
+	opReconnect  = "reconnect"
+)
+
+// StateError is returned for an operation the session is not in a state for,
+// such as ACK before BindRTPtoRoom or BindRTPtoRoom after DisconnectFromRoom.
+// It matches ErrInvalidState with errors.Is, and depending on the state
+// ErrSessionNotBound, ErrAlreadyBound, ErrAlreadyActive, ErrSessionClosed or,
+// for a session hung up by a timeout, ErrMediaTimeout.
+type StateError struct {
+	SessionID string
+	Op        string
+	State     SessionState
+	// Reason is why a closing or closed session ended.
+	Reason EndReason
+}
+
+func (e *StateError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("session %s: cannot %s when %s (%s)", e.SessionID, e.Op, e.State, e.Reason)
+	}
+
+	return fmt.Sprintf("session %s: cannot %s when %s", e.SessionID, e.Op, e.State)
+}
+
+func (e *StateError) Is(target error) bool {
+	switch target {
+	case ErrInvalidState:
+		return true
+	case ErrSessionNotBound:
+		return e.Op != opBind && e.State < StateBound
+	case ErrAlreadyBound:
+		return e.Op == opBind && (e.State == StateBound || e.State == StateActive)
+	case ErrAlreadyActive:
+		return e.Op == opACK && e.State == StateActive
+	case ErrSessionClosed:
+		return e.State >= StateClosing
+	case ErrMediaTimeout:
+		return e.State >= StateClosing && errors.Is(e.Reason.Err(), ErrMediaTimeout)
+	}
+
+	return false
+}
+
+// State returns the lifecycle state of the session.
+func (r *Manager) State(ctx context.Context, sID string) (SessionState, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	session, ok := r.getSession(sID)
+	if !ok {
+		return 0, fmt.Errorf("session %s: %w", sID, ErrNotFound)
+	}
+
+	return session.getState(), nil
+}
+
+// connected records the room joined by ConnectToRoom.
+func (s *session) connected(room *lksdk.Room) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if err := s.transitionLocked(opConnect, StateConnected, StateConnecting); err != nil {
+		return err
+	}
+
+	s.room = room
+
+	return nil
+}
+
+// closing starts tearing the session down for reason and returns the state
+// it was in. Only the first of concurrent calls succeeds.
+func (s *session) closing(reason EndReason) (SessionState, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	prev := s.state
+
+	if err := s.transitionLocked(opDisconnect, StateClosing, StateConnecting, StateConnected, StateBound, StateActive); err != nil {
+		return prev, err
+	}
+
+	s.endReason = reason
+
+	return prev, nil
+}
+
+func (s *session) setClosed() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.state = StateClosed
+}
+
+func (s *session) getState() SessionState {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.state
+}
+
+// expect fails with a StateError unless the session is in one of states.
+func (s *session) expect(op string, states ...SessionState) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.expectLocked(op, states...)
+}
+
+func (s *session) expectLocked(op string, states ...SessionState) error {
+	if slices.Contains(states, s.state) {
+		return nil
+	}
+
+	return &StateError{SessionID: s.id, Op: op, State: s.state, Reason: s.endReason}
+}
+
+// transition moves the session to state to if it is in one of from.
+func (s *session) transition(op string, to SessionState, from ...SessionState) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.transitionLocked(op, to, from...)
+}
+
+func (s *session) transitionLocked(op string, to SessionState, from ...SessionState) error {
+	if err := s.expectLocked(op, from...); err != nil {
+		return err
+	}
+
+	s.state = to
+
+	return nil
+}
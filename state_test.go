@@ -0,0 +1,86 @@
+package rtp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSessionStateTransitions(t *testing.T) {
+	s := &session{id: "s1"}
+
+	if err := s.expect(opBind, StateConnected); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("bind while connecting: %v, want ErrInvalidState", err)
+	}
+
+	if err := s.connected(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.connected(nil); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("second connect: %v, want ErrInvalidState", err)
+	}
+
+	steps := []struct {
+		op    string
+		to    SessionState
+		from  []SessionState
+		state SessionState
+		fails bool
+	}{
+		{op: opACK, to: StateActive, from: []SessionState{StateBound}, state: StateConnected, fails: true},
+		{op: opBind, to: StateBound, from: []SessionState{StateConnected}, state: StateBound},
+		{op: opBind, to: StateBound, from: []SessionState{StateConnected}, state: StateBound, fails: true},
+		{op: opRebind, to: StateBound, from: []SessionState{StateBound, StateActive}, state: StateBound},
+		{op: opACK, to: StateActive, from: []SessionState{StateBound}, state: StateActive},
+		{op: opACK, to: StateActive, from: []SessionState{StateBound}, state: StateActive, fails: true},
+	}
+
+	for i, step := range steps {
+		err := s.transition(step.op, step.to, step.from...)
+
+		if (err != nil) != step.fails {
+			t.Fatalf("step %d, %s: %v, want failure %v", i, step.op, err, step.fails)
+		}
+
+		if state := s.getState(); state != step.state {
+			t.Fatalf("step %d, %s: state %s, want %s", i, step.op, state, step.state)
+		}
+	}
+
+	prev, err := s.closing(EndReasonDisconnected)
+	if err != nil || prev != StateActive {
+		t.Fatalf("closing: %s, %v, want the session closed from active", prev, err)
+	}
+
+	// only the first of concurrent disconnects tears the session down
+	if _, err := s.closing(EndReasonDisconnected); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("second closing: %v, want ErrInvalidState", err)
+	}
+
+	s.setClosed()
+
+	err = s.expect(opACK, StateBound)
+
+	var stateErr *StateError
+	if !errors.As(err, &stateErr) || stateErr.SessionID != "s1" || stateErr.Op != opACK || stateErr.State != StateClosed {
+		t.Errorf("ack when closed: %#v, want a StateError of s1 for ack when closed", err)
+	}
+}
+
+func TestSessionStateString(t *testing.T) {
+	names := map[SessionState]string{
+		StateConnecting:  "connecting",
+		StateConnected:   "connected",
+		StateBound:       "bound",
+		StateActive:      "active",
+		StateClosing:     "closing",
+		StateClosed:      "closed",
+		SessionState(42): "SessionState(42)",
+	}
+
+	for state, name := range names {
+		if state.String() != name {
+			t.Errorf("%d: %q, want %q", int32(state), state.String(), name)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package rtp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/livekit/media-sdk/mixer"
+	"github.com/livekit/media-sdk/rtp"
+	"github.com/pion/rtcp"
+)
+
+// Stats is a snapshot of the media statistics of a session.
+type Stats struct {
+	PacketsIn  uint64
+	BytesIn    uint64
+	PacketsOut uint64
+	BytesOut   uint64
+
+	// UnexpectedPayloadTypes counts RTP packets dropped for a payload type that was not negotiated.
+	UnexpectedPayloadTypes uint64
+
+	RTCPPacketsIn  uint64
+	RTCPPacketsOut uint64
+
+	// PacketsLost is computed from the RTP sequence numbers received from the peer.
+	PacketsLost int64
+	// Jitter is the RFC 3550 interarrival jitter of the RTP received from the peer.
+	Jitter time.Duration
+	// RTT is taken from the last RTCP report of the peer that referenced one of our SRs.
+	RTT time.Duration
+
+	// RemoteFractionLost and RemotePacketsLost are reported by the peer for the RTP we send.
+	RemoteFractionLost float64
+	RemotePacketsLost  uint32
+
+	JitterBuffer JitterBufferStats
+
+	// LostFrames counts the frames missing from the RTP received from the peer.
+	// ConcealedFrames of them were synthesized, RecoveredFrames restored from Opus FEC.
+	LostFrames      uint64
+	ConcealedFrames uint64
+	RecoveredFrames uint64
+
+	Mixer  MixerStats
+	Tracks []TrackStats
+}
+
+// JitterBufferStats describes the buffer reordering the RTP received from the peer.
+type JitterBufferStats struct {
+	// Depth is the number of packets currently held.
+	Depth int
+	// Latency is how long a packet is currently held waiting for earlier ones.
+	Latency time.Duration
+
+	Duplicates uint64
+	// LateDropped counts packets that arrived after their playout time.
+	LateDropped uint64
+	// Lost counts packets given up on when their playout time passed.
+	Lost uint64
+}
+
+// MixerStats mirrors the counters of mixer.Stats.
+type MixerStats struct {
+	Tracks       int64
+	TracksTotal  uint64
+	Restarts     uint64
+	TimingResets uint64
+
+	Mixes         uint64
+	TimedMixes    uint64
+	JumpMixes     uint64
+	ZeroMixes     uint64
+	NegativeMixes uint64
+
+	InputSamples        uint64
+	InputFrames         uint64
+	InputSamplesDropped uint64
+	InputFramesDropped  uint64
+
+	MixedSamples uint64
+	MixedFrames  uint64
+
+	OutputSamples uint64
+	OutputFrames  uint64
+
+	WriteErrors  uint64
+	BlockedMixes uint64
+}
+
+// TrackStats describes a subscribed LiveKit track mixed into the RTP leg.
+type TrackStats struct {
+	TrackID  string
+	Identity string
+	Packets  uint64
+	Bytes    uint64
+}
+
+func newMixerStats(s *mixer.Stats) MixerStats {
+	return MixerStats{
+		Tracks:       s.Tracks.Load(),
+		TracksTotal:  s.TracksTotal.Load(),
+		Restarts:     s.Restarts.Load(),
+		TimingResets: s.TimingResets.Load(),
+
+		Mixes:         s.Mixes.Load(),
+		TimedMixes:    s.TimedMixes.Load(),
+		JumpMixes:     s.JumpMixes.Load(),
+		ZeroMixes:     s.ZeroMixes.Load(),
+		NegativeMixes: s.NegativeMixes.Load(),
+
+		InputSamples:        s.InputSamples.Load(),
+		InputFrames:         s.InputFrames.Load(),
+		InputSamplesDropped: s.InputSamplesDropped.Load(),
+		InputFramesDropped:  s.InputFramesDropped.Load(),
+
+		MixedSamples: s.MixedSamples.Load(),
+		MixedFrames:  s.MixedFrames.Load(),
+
+		OutputSamples: s.OutputSamples.Load(),
+		OutputFrames:  s.OutputFrames.Load(),
+
+		WriteErrors:  s.WriteErrors.Load(),
+		BlockedMixes: s.BlockedMixes.Load(),
+	}
+}
+
+// streamStats keeps RFC 3550 sender and receiver state of the RTP leg. It is
+// owned by the session, so the counters survive a rebind.
+type streamStats struct {
+	mx sync.Mutex
+
+	metrics *metrics
+
+	clockRate int
+
+	packetsIn, bytesIn   uint64
+	packetsOut, bytesOut uint64
+
+	unexpectedTypes               uint64
+	rtcpPacketsIn, rtcpPacketsOut uint64
+	// lostMetered is the loss already counted in metrics
+	lostMetered int64
+
+	// receiver state, RFC 3550 Appendix A.1 and A.8
+	started      bool
+	ssrcIn       uint32
+	baseSeq      uint32
+	maxSeq       uint16
+	cycles       uint32
+	received     uint64
+	lostPrevious int64
+	since        time.Time
+	hasTransit   bool
+	transit      int64
+	jitter       float64
+
+	// last SR of the peer, echoed back in our reception reports
+	lastSR   uint32
+	lastSRAt time.Time
+
+	// receiver state at the previous report, for the fraction lost
+	expectedPrior uint64
+	receivedPrior uint64
+
+	// sender state
+	ssrcOut   uint32
+	cname     string
+	lastTSOut uint32
+	lastOutAt time.Time
+
+	rtt                time.Duration
+	remoteFractionLost float64
+	remotePacketsLost  uint32
+
+	jitterBuffer JitterBufferStats
+
+	lostFrames, concealedFrames, recoveredFrames uint64
+}
+
+func newStreamStats(ssrc uint32, cname string, metrics *metrics) *streamStats {
+	return &streamStats{
+		metrics: metrics,
+		ssrcOut: ssrc,
+		cname:   cname,
+	}
+}
+
+func (s *streamStats) SetClockRate(clockRate int) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.clockRate != clockRate {
+		// transit times in different units can't be compared
+		s.hasTransit = false
+	}
+
+	s.clockRate = clockRate
+}
+
+func (s *streamStats) OnRTPIn(h *rtp.Header, payloadSize int, arrival time.Time) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.packetsIn++
+	s.bytesIn += uint64(payloadSize)
+	s.metrics.rtp(dirIn, payloadSize)
+
+	if !s.started {
+		s.since = arrival
+	}
+
+	if !s.started || s.ssrcIn != h.SSRC {
+		s.lostPrevious += s.lostLocked()
+		s.started, s.ssrcIn = true, h.SSRC
+		s.baseSeq, s.maxSeq, s.cycles, s.received = uint32(h.SequenceNumber), h.SequenceNumber, 0, 0
+		s.expectedPrior, s.receivedPrior = 0, 0
+		s.hasTransit = false
+	}
+
+	s.received++
+
+	if seqNewer(h.SequenceNumber, s.maxSeq) {
+		if h.SequenceNumber < s.maxSeq {
+			s.cycles += 1 << 16
+		}
+
+		s.maxSeq = h.SequenceNumber
+	}
+
+	if s.clockRate == 0 {
+		return
+	}
+
+	// relative to the first packet, so the product does not overflow
+	arrivalTS := int64(arrival.Sub(s.since)) * int64(s.clockRate) / int64(time.Second)
+	transit := arrivalTS - int64(h.Timestamp)
+
+	if s.hasTransit {
+		d := transit - s.transit
+		if d < 0 {
+			d = -d
+		}
+
+		s.jitter += (float64(d) - s.jitter) / 16
+	}
+
+	s.hasTransit, s.transit = true, transit
+}
+
+func (s *streamStats) OnRTPOut(h *rtp.Header, payloadSize int) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.packetsOut++
+	s.bytesOut += uint64(payloadSize)
+	s.metrics.rtp(dirOut, payloadSize)
+	s.lastTSOut, s.lastOutAt = h.Timestamp, time.Now()
+}
+
+func (s *streamStats) OnRTCPIn(pkts []rtcp.Packet, arrival time.Time) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.rtcpPacketsIn += uint64(len(pkts))
+	s.metrics.rtcp(dirIn, len(pkts))
+
+	for _, p := range pkts {
+		switch p := p.(type) {
+		case *rtcp.SenderReport:
+			s.lastSR, s.lastSRAt = uint32(p.NTPTime>>16), arrival
+			s.onReportsLocked(p.Reports, arrival)
+		case *rtcp.ReceiverReport:
+			s.onReportsLocked(p.Reports, arrival)
+		}
+	}
+}
+
+func (s *streamStats) onReportsLocked(reports []rtcp.ReceptionReport, arrival time.Time) {
+	for _, report := range reports {
+		if report.SSRC != s.ssrcOut {
+			continue
+		}
+
+		s.metrics.rtpLoss(dirOut, int64(report.TotalLost)-int64(s.remotePacketsLost))
+
+		if s.clockRate != 0 {
+			s.metrics.jitter(dirOut, time.Duration(report.Jitter)*time.Second/time.Duration(s.clockRate))
+		}
+
+		s.remoteFractionLost = float64(report.FractionLost) / 256
+		s.remotePacketsLost = report.TotalLost
+
+		if report.LastSenderReport == 0 {
+			continue
+		}
+
+		// RTT = A - LSR - DLSR, all in 1/65536 seconds
+		rtt := ntpCompact(arrival) - report.LastSenderReport - report.Delay
+		if int32(rtt) > 0 {
+			s.rtt = time.Duration(rtt) * time.Second / 65536
+		}
+	}
+}
+
+func (s *streamStats) OnJitterBuffer(depth int, latency time.Duration, duplicates, lateDropped, lost uint64) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.jitterBuffer.Depth, s.jitterBuffer.Latency = depth, latency
+	s.jitterBuffer.Duplicates += duplicates
+	s.jitterBuffer.LateDropped += lateDropped
+	s.jitterBuffer.Lost += lost
+}
+
+func (s *streamStats) OnLostFrames(lost, concealed, recovered uint64) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.lostFrames += lost
+	s.concealedFrames += concealed
+	s.recoveredFrames += recovered
+}
+
+func (s *streamStats) OnRTCPOut(packets int) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.rtcpPacketsOut += uint64(packets)
+	s.metrics.rtcp(dirOut, packets)
+}
+
+func (s *streamStats) OnUnexpectedPayloadType() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.unexpectedTypes++
+	s.metrics.unexpectedPayloadType()
+}
+
+// meterLocked feeds the loss and jitter of the RTP from the peer to the
+// metrics, once per report interval.
+func (s *streamStats) meterLocked() {
+	if !s.started {
+		return
+	}
+
+	s.metrics.jitter(dirIn, s.jitterLocked())
+
+	// duplicates can lower the loss, it is only counted as it grows
+	if lost := s.lostPrevious + s.lostLocked(); lost > s.lostMetered {
+		s.metrics.rtpLoss(dirIn, lost-s.lostMetered)
+		s.lostMetered = lost
+	}
+}
+
+// Jitter returns the interarrival jitter of the RTP received from the peer.
+func (s *streamStats) Jitter() time.Duration {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.jitterLocked()
+}
+
+func (s *streamStats) jitterLocked() time.Duration {
+	if s.clockRate == 0 {
+		return 0
+	}
+
+	return time.Duration(s.jitter * float64(time.Second) / float64(s.clockRate))
+}
+
+func (s *streamStats) lostLocked() int64 {
+	if !s.started {
+		return 0
+	}
+
+	expected := int64(s.cycles) + int64(s.maxSeq) - int64(s.baseSeq) + 1
+
+	return expected - int64(s.received)
+}
+
+func (s *streamStats) Fill(stats *Stats) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	stats.PacketsIn, stats.BytesIn = s.packetsIn, s.bytesIn
+	stats.PacketsOut, stats.BytesOut = s.packetsOut, s.bytesOut
+	stats.UnexpectedPayloadTypes = s.unexpectedTypes
+	stats.RTCPPacketsIn, stats.RTCPPacketsOut = s.rtcpPacketsIn, s.rtcpPacketsOut
+	stats.PacketsLost = s.lostPrevious + s.lostLocked()
+	stats.RTT = s.rtt
+	stats.RemoteFractionLost, stats.RemotePacketsLost = s.remoteFractionLost, s.remotePacketsLost
+	stats.Jitter = s.jitterLocked()
+	stats.JitterBuffer = s.jitterBuffer
+	stats.LostFrames, stats.ConcealedFrames, stats.RecoveredFrames = s.lostFrames, s.concealedFrames, s.recoveredFrames
+}
+
+// seqNewer tells if a comes after b, taking wraparound into account.
+func seqNewer(a, b uint16) bool {
+	return a != b && a-b < 1<<15
+}
+
+// ntpCompact returns the middle 32 bits of the NTP timestamp of t.
+func ntpCompact(t time.Time) uint32 {
+	return uint32(ntpTime(t) >> 16)
+}
+
+// ntpTime converts t to a 64 bit NTP timestamp.
+func ntpTime(t time.Time) uint64 {
+	const ntpEpochOffset = 2208988800
+
+	secs := uint64(t.Unix()) + ntpEpochOffset
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+
+	return secs<<32 | frac
+}
+
+// Stats returns a snapshot of the media statistics of the session.
+func (r *Manager) Stats(ctx context.Context, sID string) (*Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	session, ok := r.getSession(sID)
+
+	if !ok {
+		return nil, fmt.Errorf("session %s: %w", sID, ErrNotFound)
+	}
+
+	stats := &Stats{
+		Mixer: newMixerStats(session.stats),
+	}
+
+	session.streamStats.Fill(stats)
+
+	for _, input := range session.getInputs() {
+		stats.Tracks = append(stats.Tracks, input.Stats())
+	}
+
+	return stats, nil
+}
@@ -5,20 +5,27 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/livekit/media-sdk/rtp"
+	"github.com/livekit/protocol/logger"
 	"github.com/pion/rtcp"
 )
 
 const (
 	deadlineUDP = time.Minute
+
+	errNoPacket = errCustom("no packet")
 )
 
 type streamRTP struct {
+	log logger.Logger
+
 	connRTP, connRTCP *net.UDPConn
+	rtcpMux           bool
 	buff              []byte
 
 	rAddrRTPWait chan struct{}
@@ -29,9 +36,34 @@ type streamRTP struct {
 	rAddrRTCPMx   sync.Mutex
 	rAddrRTCP     net.Addr
 
-	closed atomic.Bool
+	closed  atomic.Bool
+	done    chan struct{}
+	readers sync.WaitGroup
 
 	rtpBuff chan rtp.Packet
+	jitter  *jitterBuffer
+
+	// the RTP held back while the room reconnects, see pause
+	pauseMx     sync.Mutex
+	paused      bool
+	pauseBuffer time.Duration
+	heldBack    []heldPacket
+
+	stats *streamStats
+	srtp  *srtpContexts
+
+	// lastRTP and lastRTCP are the arrival times of the last packets, in Unix nanoseconds.
+	lastRTP, lastRTCP atomic.Int64
+	timeouts          MediaTimeouts
+	// held is set while the session put the peer on hold, see SetDirection.
+	held atomic.Bool
+
+	onTimeout func(onHold bool)
+	onDead    func(reason EndReason)
+	onBye     func(reason string)
+
+	// limits of the messages logged per packet
+	rtpErrors, rtcpErrors, rtcpDump logLimit
 }
 
 func shouldExit(err error) bool {
@@ -39,131 +71,271 @@ func shouldExit(err error) bool {
 		return true
 	}
 
-	if errNet, ok := err.(net.Error); ok && errNet.Timeout() {
-		return true
-	}
+	return isTimeout(err)
+}
 
-	return false
+func isTimeout(err error) bool {
+	errNet, ok := err.(net.Error)
+
+	return ok && errNet.Timeout()
 }
 
-func newStreamRTP(connRTP, connRTCP net.Conn) *streamRTP {
+// newStreamRTP starts reading RTP and RTCP from the peer. onTimeout is called
+// when no RTP arrived for timeouts.RTP, onDead when the peer is to be hung
+// up on, see MediaTimeouts, and onBye when the peer sends an RTCP BYE.
+// srtp is nil for plain RTP. With rtcpMux both travel over connRTP (RFC 5761)
+// and connRTCP is ignored.
+func newStreamRTP(
+	connRTP, connRTCP net.Conn,
+	log logger.Logger,
+	rtcpMux bool,
+	stats *streamStats,
+	srtp *srtpContexts,
+	timeouts MediaTimeouts,
+	onTimeout func(onHold bool),
+	onDead func(reason EndReason),
+	onBye func(reason string),
+) *streamRTP {
 	udpConnRTP := connRTP.(*net.UDPConn)
-	udpConnRTCP := connRTCP.(*net.UDPConn)
+
+	udpConnRTCP := udpConnRTP
+	if !rtcpMux {
+		udpConnRTCP = connRTCP.(*net.UDPConn)
+	}
 
 	c := &streamRTP{
+		log:           log,
 		connRTP:       udpConnRTP,
 		connRTCP:      udpConnRTCP,
-		buff:          make([]byte, inboundMTU),
+		rtcpMux:       rtcpMux,
+		buff:          make([]byte, maxInboundPacket),
 		rtpBuff:       make(chan rtp.Packet, 65535),
 		rAddrRTPWait:  make(chan struct{}, 1),
 		rAddrRTCPWait: make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		stats:         stats,
+		srtp:          srtp,
+		timeouts:      timeouts,
+		onTimeout:     onTimeout,
+		onDead:        onDead,
+		onBye:         onBye,
 	}
 
-	go func() {
-		buff := make([]byte, inboundMTU)
+	c.jitter = newJitterBuffer(stats, c.deliver)
 
-		for {
-			if err := udpConnRTCP.SetDeadline(time.Now().Add(deadlineUDP)); err != nil {
-				if shouldExit(err) {
-					return
-				}
+	return c
+}
 
-				fmt.Printf("Error setting the deadline for UDP: %v\n", err)
+// start starts reading the sockets, sending reports and watching the media.
+// Until then the sockets may still be read by the previous binding.
+func (c *streamRTP) start() {
+	c.lastRTP.Store(time.Now().UnixNano())
 
-				continue
-			}
+	go c.sendReports()
+	go c.watchMedia()
+
+	if c.rtcpMux {
+		c.readers.Add(1)
+
+		go func() {
+			defer c.readers.Done()
+			defer close(c.rtpBuff)
+			defer c.jitter.Close()
 
-			n, rAddr, err := udpConnRTCP.ReadFromUDP(buff)
-			if err != nil {
-				if shouldExit(err) {
-					fmt.Printf("RTCP connection closed, stopping read loop\n")
+			c.readLoop(c.connRTP, c.buff, "RTP/RTCP", func(data []byte, rAddr *net.UDPAddr) {
+				if isRTCP(data) {
+					c.handleRTCP(data, rAddr)
 
 					return
 				}
 
-				// close even if Deadline has been exceeded
-				fmt.Println("RTCP read error:", err)
+				c.handleRTP(data, rAddr)
+			})
+		}()
+
+		return
+	}
+
+	c.readers.Add(2)
+
+	go func() {
+		defer c.readers.Done()
+
+		c.readLoop(c.connRTCP, make([]byte, inboundMTU), "RTCP", c.handleRTCP)
+	}()
+
+	go func() {
+		defer c.readers.Done()
+		defer close(c.rtpBuff)
+		defer c.jitter.Close()
 
+		c.readLoop(c.connRTP, c.buff, "RTP", c.handleRTP)
+	}()
+}
+
+// isRTCP demultiplexes RTP and RTCP sharing a port, RFC 5761 section 4:
+// RTCP packet types 192-223 never collide with RTP payload types in use.
+func isRTCP(data []byte) bool {
+	return len(data) >= 2 && 192 <= data[1] && data[1] <= 223
+}
+
+// readLoop reads from conn until it is closed or the stream is detached.
+// Inactivity of the peer is left to watchMedia.
+func (c *streamRTP) readLoop(conn *net.UDPConn, buff []byte, kind string, handle func([]byte, *net.UDPAddr)) {
+	for {
+		if err := conn.SetDeadline(time.Now().Add(deadlineUDP)); err != nil {
+			if shouldExit(err) {
 				return
 			}
 
-			c.SetRemoteAddrRTCP(rAddr)
+			c.log.Warnw("failed to set UDP deadline", err, "kind", kind)
 
-			pkts, err := rtcp.Unmarshal(buff[:n])
-			if err != nil {
-				fmt.Println("streamRTP: RTCP unmarshal error:", err)
+			continue
+		}
 
-				continue
-			}
+		if c.closed.Load() {
+			return
+		}
 
-			if !printRTCPfromClient {
+		n, rAddr, err := conn.ReadFromUDP(buff)
+		if err != nil {
+			// the deadline only serves to interrupt a detached stream
+			if isTimeout(err) && !c.closed.Load() {
 				continue
 			}
 
-			for _, p := range pkts {
-				fmt.Printf("Got RTCP from %s: %+v\n", rAddr, p)
+			if shouldExit(err) {
+				c.log.Debugw("connection closed, stopping read loop", "kind", kind)
+
+				return
 			}
+
+			// close even if Deadline has been exceeded
+			c.log.Warnw("read failed, stopping read loop", err, "kind", kind)
+
+			return
 		}
-	}()
 
-	go func() {
-		defer close(c.rtpBuff)
+		handle(buff[:n], rAddr)
+	}
+}
 
-		for {
-			if err := udpConnRTP.SetDeadline(time.Now().Add(deadlineUDP)); err != nil {
-				if shouldExit(err) {
-					return
-				}
+func (c *streamRTP) handleRTCP(data []byte, rAddr *net.UDPAddr) {
+	var err error
 
-				fmt.Printf("Error setting the deadline for UDP: %v\n", err)
+	if c.srtp != nil {
+		if data, err = c.srtp.DecryptRTCP(data); err != nil {
+			c.rtcpErrors.Debugw(c.log, "failed to decrypt SRTCP", "error", err, "remoteAddr", rAddr)
 
-				continue
-			}
+			return
+		}
+	}
+
+	pkts, err := rtcp.Unmarshal(data)
+	if err != nil {
+		c.rtcpErrors.Debugw(c.log, "failed to unmarshal RTCP", "error", err, "remoteAddr", rAddr)
 
-			n, rAddr, err := udpConnRTP.ReadFromUDP(c.buff)
-			if err != nil {
-				if shouldExit(err) {
-					fmt.Println("RTP connection closed, stopping read loop")
+		return
+	}
 
-					return
-				}
+	// only an authenticated packet may move the peer, see handleRTP
+	c.SetRemoteAddrRTCP(rAddr)
+	c.lastRTCP.Store(time.Now().UnixNano())
 
-				fmt.Printf("streamRTP: ReadRTP failed: %s\n", err)
+	c.stats.OnRTCPIn(pkts, time.Now())
 
-				// close even if Deadline has been exceeded
-				return
-			}
+	for _, p := range pkts {
+		if bye, ok := p.(*rtcp.Goodbye); ok {
+			c.log.Infow("received RTCP BYE", "remoteAddr", rAddr, "reason", bye.Reason)
 
-			c.SetRemoteAddrRTP(rAddr)
+			c.onBye(bye.Reason)
+		}
+	}
 
-			pkt := rtp.Packet{}
+	c.rtcpDump.Debugw(c.log, "received RTCP", "remoteAddr", rAddr, "packets", pkts)
+}
 
-			if err := pkt.Unmarshal(c.buff[:n]); err != nil {
-				fmt.Printf("streamRTP: RTP unmarshal error: %s\n", err)
+func (c *streamRTP) handleRTP(data []byte, rAddr *net.UDPAddr) {
+	var err error
 
-				continue
-			}
+	if c.srtp != nil {
+		if data, err = c.srtp.DecryptRTP(data); err != nil {
+			c.rtpErrors.Debugw(c.log, "failed to decrypt SRTP", "error", err, "remoteAddr", rAddr)
 
-			c.rtpBuff <- pkt
+			return
 		}
-	}()
+	} else {
+		// the packet outlives the read buffer, it is queued in the jitter buffer
+		data = slices.Clone(data)
+	}
 
-	return c
+	pkt := rtp.Packet{}
+
+	if err := pkt.Unmarshal(data); err != nil {
+		c.rtpErrors.Debugw(c.log, "failed to unmarshal RTP", "error", err, "remoteAddr", rAddr)
+
+		return
+	}
+
+	// the media follows the peer only once a packet was accepted, so with
+	// SRTP a forged packet cannot redirect it
+	c.SetRemoteAddrRTP(rAddr)
+
+	if c.rtcpMux {
+		c.SetRemoteAddrRTCP(rAddr)
+	}
+
+	arrival := time.Now()
+	c.lastRTP.Store(arrival.UnixNano())
+
+	c.stats.OnRTPIn(&pkt.Header, len(pkt.Payload), arrival)
+	c.jitter.Push(&pkt, arrival)
 }
 
 func (c *streamRTP) Close() {
 	if c.closed.Swap(true) {
-		fmt.Printf("streamRTP already closed\n")
+		c.log.Debugw("streamRTP already closed")
+
+		return
+	}
+
+	close(c.done)
+	c.closeConns()
+}
 
+// Detach stops the read loops and waits for them to exit, but leaves both
+// sockets open, so a rebind can hand the same sockets to a new streamRTP.
+func (c *streamRTP) Detach() {
+	if c.closed.Swap(true) {
 		return
 	}
 
+	close(c.done)
+
+	now := time.Now()
+
+	if err := c.connRTP.SetReadDeadline(now); err != nil && !shouldExit(err) {
+		c.log.Warnw("failed to interrupt RTP read loop", err)
+	}
+
+	if err := c.connRTCP.SetReadDeadline(now); err != nil && !shouldExit(err) {
+		c.log.Warnw("failed to interrupt RTCP read loop", err)
+	}
+
+	c.readers.Wait()
+}
+
+func (c *streamRTP) closeConns() {
 	if err := c.connRTP.Close(); err != nil {
-		fmt.Printf("failed to close RTP conn: %v\n", err)
+		c.log.Warnw("failed to close RTP conn", err)
+	}
+
+	if c.rtcpMux {
+		return
 	}
 
 	if err := c.connRTCP.Close(); err != nil {
-		fmt.Printf("failed to close RTCP conn: %v\n", err)
+		c.log.Warnw("failed to close RTCP conn", err)
 	}
 }
 
@@ -184,12 +356,20 @@ func (c *streamRTP) WriteRTP(h *rtp.Header, payload []byte) (int, error) {
 		return 0, fmt.Errorf("streamRTP: failed to marshal pkt: %w", err)
 	}
 
+	if c.srtp != nil {
+		if data, err = c.srtp.EncryptRTP(data, h); err != nil {
+			return 0, fmt.Errorf("streamRTP: failed to encrypt pkt: %w", err)
+		}
+	}
+
 	n, err := c.connRTP.WriteTo(data, rAddr)
 	if err != nil {
 		// close even if Deadline has been exceeded
 		return n, fmt.Errorf("streamRTP: failed to write data: %w", err)
 	}
 
+	c.stats.OnRTPOut(h, len(payload))
+
 	return n, nil
 }
 
@@ -202,6 +382,14 @@ func (c *streamRTP) GetRemoteAddrRTP() net.Addr {
 	return c.rAddrRTP
 }
 
+// remoteAddr is the RTP address of the peer for logging, nil while unknown.
+func (c *streamRTP) remoteAddr() net.Addr {
+	c.rAddrRTPMx.Lock()
+	defer c.rAddrRTPMx.Unlock()
+
+	return c.rAddrRTP
+}
+
 func (c *streamRTP) SetRemoteAddrRTP(addr net.Addr) {
 	c.rAddrRTPMx.Lock()
 	defer c.rAddrRTPMx.Unlock()
@@ -244,3 +432,24 @@ func (c *streamRTP) ReadRTP(h *rtp.Header, payload []byte) (int, error) {
 
 	return len(pkt.Payload), nil
 }
+
+// ReadRTPTimeout is ReadRTP waiting at most timeout. It returns errNoPacket
+// if no packet arrived in time.
+func (c *streamRTP) ReadRTPTimeout(h *rtp.Header, payload []byte, timeout time.Duration) (int, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case pkt, ok := <-c.rtpBuff:
+		if !ok {
+			return 0, io.EOF
+		}
+
+		copy(payload, pkt.Payload)
+		*h = pkt.Header
+
+		return len(pkt.Payload), nil
+	case <-timer.C:
+		return 0, errNoPacket
+	}
+}
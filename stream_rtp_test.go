@@ -0,0 +1,150 @@
+package rtp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/livekit/media-sdk/rtp"
+	"github.com/livekit/protocol/logger"
+	"github.com/pion/rtcp"
+)
+
+func listenTestUDP(t *testing.T) *net.UDPConn {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func TestStreamRTPLatchesOnlyAuthenticated(t *testing.T) {
+	local, remote := newSRTPPair(t, SRTPAES128CMHMACSHA180)
+
+	connRTP, connRTCP := listenTestUDP(t), listenTestUDP(t)
+
+	stream := newStreamRTP(connRTP, connRTCP, logger.GetLogger(), false, newStreamStats(1, "cname", nil), local, MediaTimeouts{}, nil, nil, nil)
+	stream.start()
+	defer stream.Close()
+
+	peer, attacker := listenTestUDP(t), listenTestUDP(t)
+	defer peer.Close()
+	defer attacker.Close()
+
+	send := func(conn *net.UDPConn, seq uint16, srtp *srtpContexts) {
+		t.Helper()
+
+		data, h := marshalTestRTP(t, seq)
+
+		if srtp != nil {
+			var err error
+			if data, err = srtp.EncryptRTP(data, h); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := conn.WriteTo(data, connRTP.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func() uint16 {
+		t.Helper()
+
+		var h rtp.Header
+
+		if _, err := stream.ReadRTP(&h, make([]byte, 1500)); err != nil {
+			t.Fatal(err)
+		}
+
+		return h.SequenceNumber
+	}
+
+	send(peer, 1, remote)
+
+	if seq := read(); seq != 1 {
+		t.Fatalf("read seq %d, want 1", seq)
+	}
+
+	if addr := stream.GetRemoteAddrRTP(); addr.String() != peer.LocalAddr().String() {
+		t.Fatalf("remote address %v, want the peer at %v", addr, peer.LocalAddr())
+	}
+
+	// neither plain RTP nor a packet under other keys moves the media
+	other, _ := newSRTPPair(t, SRTPAES128CMHMACSHA180)
+
+	send(attacker, 2, nil)
+	send(attacker, 3, other)
+	send(peer, 4, remote)
+
+	if seq := read(); seq != 4 {
+		t.Fatalf("read seq %d, want 4 from the peer", seq)
+	}
+
+	if addr := stream.GetRemoteAddrRTP(); addr.String() != peer.LocalAddr().String() {
+		t.Errorf("remote address %v after unauthenticated packets, want the peer at %v", addr, peer.LocalAddr())
+	}
+
+	// an authenticated packet from a new address moves it
+	send(attacker, 5, remote)
+
+	if seq := read(); seq != 5 {
+		t.Fatalf("read seq %d, want 5", seq)
+	}
+
+	if addr := stream.GetRemoteAddrRTP(); addr.String() != attacker.LocalAddr().String() {
+		t.Errorf("remote address %v, want %v", addr, attacker.LocalAddr())
+	}
+}
+
+func TestIsRTCP(t *testing.T) {
+	for b := range 256 {
+		// RTP with the marker bit set and payload type 64-95 shares these bytes
+		want := 192 <= b && b <= 223
+
+		if got := isRTCP([]byte{0x80, byte(b)}); got != want {
+			t.Errorf("second byte %d: RTCP %v, want %v", b, got, want)
+		}
+	}
+
+	if isRTCP([]byte{0x80}) {
+		t.Error("a single byte is RTCP")
+	}
+}
+
+func TestStreamRTPMux(t *testing.T) {
+	connRTP := listenTestUDP(t)
+
+	stream := newStreamRTP(connRTP, nil, logger.GetLogger(), true, newStreamStats(1, "cname", nil), nil, MediaTimeouts{}, nil, nil, nil)
+	stream.start()
+	defer stream.Close()
+
+	peer := listenTestUDP(t)
+	defer peer.Close()
+
+	rr, err := rtcp.Marshal([]rtcp.Packet{&rtcp.ReceiverReport{SSRC: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := marshalTestRTP(t, 1)
+
+	for _, pkt := range [][]byte{rr, data} {
+		if _, err := peer.WriteTo(pkt, connRTP.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var h rtp.Header
+
+	if _, err := stream.ReadRTP(&h, make([]byte, 1500)); err != nil || h.SequenceNumber != 1 {
+		t.Fatalf("read seq %d, %v, want the RTP packet", h.SequenceNumber, err)
+	}
+
+	// the RR came first, it is not taken for RTP
+	if addr := stream.GetRemoteAddrRTCP(); addr.String() != peer.LocalAddr().String() {
+		t.Errorf("RTCP address %v, want the peer at %v", addr, peer.LocalAddr())
+	}
+}
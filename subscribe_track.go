@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 
+	"github.com/livekit/media-sdk/mixer"
 	"github.com/livekit/media-sdk/opus"
 	"github.com/livekit/media-sdk/rtp"
 	"github.com/livekit/protocol/logger"
@@ -13,71 +16,162 @@ import (
 	"github.com/pion/webrtc/v4"
 )
 
+// subscribedTrack decodes a remote LiveKit track into a mixer input. The
+// mixer behind it can be swapped with attach while the track keeps reading.
+type subscribedTrack struct {
+	mx sync.Mutex
+
+	id       string
+	identity string
+	log      logger.Logger
+	input    *mixer.Input
+	handler  rtp.HandlerCloser
+	closed   bool
+
+	packets, bytes atomic.Uint64
+}
+
+func newSubscribedTrack(id, identity string, log logger.Logger) *subscribedTrack {
+	return &subscribedTrack{
+		id:       id,
+		identity: identity,
+		log:      log.WithValues("trackID", id, "participant", identity),
+	}
+}
+
+func (t *subscribedTrack) attach(mix *mixer.Mixer, channels int) error {
+	input := mix.NewInput()
+	if input == nil {
+		return fmt.Errorf("subscribedTrack %s: mixer already stopped", t.id)
+	}
+
+	decoder, err := opus.Decode(input, channels, logger.GetLogger())
+	if err != nil {
+		if errClose := input.Close(); errClose != nil {
+			t.log.Warnw("failed to close mixer input", errClose)
+		}
+
+		return fmt.Errorf("subscribedTrack %s: failed to create decoder: %w", t.id, err)
+	}
+
+	handler := rtp.HandleJitter(newHandlerRTP(rtp.NewMediaStreamIn(decoder), t.log))
+
+	t.mx.Lock()
+
+	// closed while attaching, e.g. reattached as the track ended
+	if t.closed {
+		t.mx.Unlock()
+		t.release(handler, input)
+
+		return nil
+	}
+
+	prevHandler, prevInput := t.handler, t.input
+	t.handler, t.input = handler, input
+	t.mx.Unlock()
+
+	t.release(prevHandler, prevInput)
+
+	return nil
+}
+
+func (t *subscribedTrack) release(handler rtp.HandlerCloser, input *mixer.Input) {
+	if handler != nil {
+		handler.Close()
+	}
+
+	if err := input.Close(); err != nil {
+		t.log.Warnw("failed to close mixer input", err)
+	}
+}
+
+func (t *subscribedTrack) String() string {
+	return "subscribed track " + t.id
+}
+
+func (t *subscribedTrack) Stats() TrackStats {
+	return TrackStats{
+		TrackID:  t.id,
+		Identity: t.identity,
+		Packets:  t.packets.Load(),
+		Bytes:    t.bytes.Load(),
+	}
+}
+
+func (t *subscribedTrack) HandleRTP(h *rtp.Header, payload []byte) error {
+	t.packets.Add(1)
+	t.bytes.Add(uint64(len(payload)))
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	// not attached to a mixer yet, nobody to play the audio to
+	if t.handler == nil {
+		return nil
+	}
+
+	return t.handler.HandleRTP(h, payload)
+}
+
+func (t *subscribedTrack) Close() {
+	t.mx.Lock()
+	handler, input := t.handler, t.input
+	t.handler, t.input = nil, nil
+	t.closed = true
+	t.mx.Unlock()
+
+	t.release(handler, input)
+}
+
 func (r *Manager) subscribeTrack(sID, identity string) func(*webrtc.TrackRemote, *lksdk.RemoteTrackPublication, *lksdk.RemoteParticipant) {
 	return func(track *webrtc.TrackRemote, rTrack *lksdk.RemoteTrackPublication, rp *lksdk.RemoteParticipant) {
-		fmt.Printf("%s: Started OnTrackSubscribed: %s (identity: %s ?== %s)\n", sID, track.ID(), rp.Identity(), identity)
-
-		defer fmt.Printf("%s: Finished OnTrackSubscribed: %s (identity: %s ?== %s)\n", sID, track.ID(), rp.Identity(), identity)
+		r.events.OnTrackSubscribed(sID, rp.Identity(), track.ID())
 
-		session, ok := r.session[sID]
+		session, ok := r.getSession(sID)
 		if !ok {
-			fmt.Printf("OnTrackSubscribed: session %s not found %s (identity: %s ?== %s)\n", sID, track.ID(), rp.Identity(), identity)
+			r.log.Infow("track subscribed for unknown session",
+				"sessionID", sID, "identity", identity, "trackID", track.ID(), "participant", rp.Identity())
 
 			return
 		}
 
-		mixer := session.mixer
-		if mixer == nil {
-			fmt.Printf("OnTrackSubscribed: mixer in session %s not ready %s (identity: %s ?== %s)\n", sID, track.ID(), rp.Identity(), identity)
+		log := session.log.WithValues("trackID", track.ID(), "participant", rp.Identity())
+		log.Infow("track subscribed")
 
-			return
-		}
+		defer log.Infow("track finished")
 
-		connRTCP := session.connRTCP
-		if connRTCP == nil {
-			fmt.Printf("OnTrackSubscribed: connRTCP in session %s not ready %s (identity: %s ?== %s)\n", sID, track.ID(), rp.Identity(), identity)
+		mixer, channels := session.getMixer()
+		if mixer == nil {
+			log.Infow("mixer not ready, ignoring track")
 
 			return
 		}
 
-		streamRTP := session.streamRTP
-		if streamRTP == nil {
-			fmt.Printf("OnTrackSubscribed: streamRTP in session %s not ready %s (identity: %s ?== %s)\n", sID, track.ID(), rp.Identity(), identity)
+		if session.getStreamRTP() == nil {
+			log.Infow("RTP not bound, ignoring track")
 
 			return
 		}
 
-		mTrack := mixer.NewInput()
-
-		defer func() {
-			if err := mTrack.Close(); err != nil {
-				fmt.Printf("OnTrackSubscribed: failed to close mTrack %s %s (identity: %s ?== %s)\n", sID, track.ID(), rp.Identity(), identity)
-			}
-
-			mixer.RemoveInput(mTrack)
-		}()
+		input := newSubscribedTrack(track.ID(), rp.Identity(), session.log)
 
-		decoder, err := opus.Decode(mTrack, session.channels, logger.GetLogger())
-		if err != nil {
-			fmt.Printf("OnTrackSubscribed: failed to create decoder in session %s %s (identity: %s ?== %s)\n", sID, track.ID(), rp.Identity(), identity)
+		if err := input.attach(mixer, channels); err != nil {
+			log.Warnw("failed to attach track", err)
 
 			return
 		}
 
-		handler := newHandlerRTP(rtp.NewMediaStreamIn(decoder))
-
-		defer handler.Close()
-
-		handlerJitter := rtp.HandleJitter(handler)
+		session.addInput(input)
 
-		defer handlerJitter.Close()
+		defer input.Close()
+		defer session.removeInput(input)
 
-		if err := rtp.HandleLoop(track, handlerJitter); err != nil {
+		if err := rtp.HandleLoop(track, input); err != nil {
 			if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
 				return
 			}
 
-			fmt.Printf("OnTrackSubscribed: session %s failed to exit HandleLoop %s (identity: %s ?== %s)\n", sID, track.ID(), rp.Identity(), identity)
+			log.Warnw("track read loop failed", err)
 		}
 	}
 }